@@ -0,0 +1,357 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/override"
+	"github.com/koovee/thermia/temperature"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	planHours          = 48
+	historySize        = 7 * 24 // decisions kept in history
+	apiBodyLimit       = 1 << 16
+	defaultOverrideFor = 3 * time.Hour
+)
+
+// api serves controller status and accepts overrides and reload requests over HTTP. Main loop publishes a snapshot
+// of the state after every control cycle, so handlers never access the state directly.
+type api struct {
+	clock           clock.Clock
+	token           string // required for the requests that change the state (empty disables the check)
+	m               sync.Mutex
+	snapshot        snapshot
+	history         []apiDecision
+	reload          chan<- struct{}
+	overrideChanged chan<- struct{}
+}
+
+// snapshot is the controller state published to the API
+type snapshot struct {
+	status    apiStatus
+	prices    []apiPrice
+	plan      []planHour
+	cs        control.State
+	overrides *override.Store
+	evuRelay  bool
+	push      *temperature.PushSource // nil unless indoor temperature is pushed
+}
+
+type apiStatus struct {
+	Time            time.Time          `json:"time"`
+	Version         string             `json:"version"`
+	DryRun          bool               `json:"dryRun"`
+	Mode            control.Mode       `json:"mode"`
+	Boost           bool               `json:"boost"`
+	Strategy        string             `json:"strategy"`
+	Profile         string             `json:"profile,omitempty"`
+	Reason          string             `json:"reason"`
+	FrostProtection bool               `json:"frostProtection"`
+	Override        *override.Override `json:"override,omitempty"`
+	Price           *float64           `json:"price,omitempty"`
+	Outdoor         *float64           `json:"outdoor,omitempty"`
+	Indoor          *float64           `json:"indoor,omitempty"`
+	ActiveHours     int                `json:"activeHours"`
+}
+
+type apiDecision struct {
+	Time     time.Time    `json:"time"`
+	Mode     control.Mode `json:"mode"`
+	Boost    bool         `json:"boost"`
+	Strategy string       `json:"strategy"`
+	Profile  string       `json:"profile,omitempty"`
+	Reason   string       `json:"reason"`
+}
+
+type apiPrice struct {
+	Time  time.Time `json:"time"`
+	Price float64   `json:"price"`
+}
+
+type apiOverrideRequest struct {
+	Mode     string `json:"mode"`
+	Boost    bool   `json:"boost"`
+	Duration string `json:"duration"`
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func newAPI(clk clock.Clock, token string, reload, overrideChanged chan<- struct{}) *api {
+	return &api{clock: clk, token: token, reload: reload, overrideChanged: overrideChanged}
+}
+
+// listen starts the HTTP server in the background
+func (a *api) listen(addr string) *http.Server {
+	server := &http.Server{Addr: addr, Handler: a.handler(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		log.Info("HTTP API listening", "address", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error("HTTP API failed", "error", err)
+		}
+	}()
+	return server
+}
+
+// publish stores a snapshot of the state and adds the latest decision to the history
+func (a *api) publish(s state, d decision, now time.Time) {
+	status := apiStatus{
+		Time:            now,
+		Version:         version,
+		DryRun:          s.dryRun,
+		Mode:            d.mode,
+		Boost:           d.boost,
+		Strategy:        d.strategy,
+		Profile:         d.profile,
+		Reason:          d.reason,
+		FrostProtection: d.frostProtection,
+		ActiveHours:     s.activeHours,
+	}
+	if s.overrides != nil {
+		if o, ok := s.overrides.Active(now); ok {
+			status.Override = &o
+		}
+	}
+	if price, err := s.sp.GetPrice(now); err == nil {
+		status.Price = &price
+	}
+	if s.outdoor.known {
+		status.Outdoor = &s.outdoor.current
+	}
+	if s.indoor.known {
+		status.Indoor = &s.indoor.temperature
+	}
+
+	// known prices from the start of today
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var prices []apiPrice
+	for i, price := range s.sp.PricesFrom(midnight, planHours) {
+		prices = append(prices, apiPrice{Time: midnight.Add(time.Duration(i) * time.Hour), Price: price})
+	}
+
+	plan := s.plan(now, planHours)
+	push, _ := s.indoor.source.(*temperature.PushSource)
+
+	a.m.Lock()
+	defer a.m.Unlock()
+	a.snapshot = snapshot{
+		status:    status,
+		prices:    prices,
+		plan:      plan,
+		cs:        s.cs,
+		overrides: s.overrides,
+		evuRelay:  s.cfg.Relays.EVUURL != "",
+		push:      push,
+	}
+	a.history = append(a.history, apiDecision{
+		Time:     now,
+		Mode:     d.mode,
+		Boost:    d.boost,
+		Strategy: d.strategy,
+		Profile:  d.profile,
+		Reason:   d.reason,
+	})
+	if len(a.history) > historySize {
+		a.history = a.history[len(a.history)-historySize:]
+	}
+}
+
+func (a *api) current() snapshot {
+	a.m.Lock()
+	defer a.m.Unlock()
+	return a.snapshot
+}
+
+func (a *api) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/status", a.get(func(context.Context) (interface{}, error) { return a.current().status, nil }))
+	mux.HandleFunc("/api/prices", a.get(func(context.Context) (interface{}, error) { return a.current().prices, nil }))
+	mux.HandleFunc("/api/plan", a.get(func(context.Context) (interface{}, error) { return a.current().plan, nil }))
+	mux.HandleFunc("/api/history", a.get(func(context.Context) (interface{}, error) {
+		a.m.Lock()
+		defer a.m.Unlock()
+		// newest first
+		history := make([]apiDecision, len(a.history))
+		for i, d := range a.history {
+			history[len(history)-1-i] = d
+		}
+		return history, nil
+	}))
+	mux.HandleFunc("/api/relays", a.get(func(ctx context.Context) (interface{}, error) {
+		status, err := a.current().cs.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return status, nil
+	}))
+	mux.HandleFunc("/api/override", a.protect(a.handleOverride))
+	mux.HandleFunc("/api/reload", a.protect(a.handleReload))
+	mux.HandleFunc("/api/temperature", a.handleTemperature)
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/", dashboard())
+	return mux
+}
+
+// get returns a handler for GET requests that responds with the value returned by f
+func (a *api) get(f func(ctx context.Context) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+			return
+		}
+		v, err := f(r.Context())
+		if err != nil {
+			writeError(w, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, v)
+	}
+}
+
+// protect returns a handler that accepts requests changing the state only with JSON content type and the API token (if
+// configured). Browsers do not send cross-site JSON requests, custom headers or DELETE requests without asking the
+// server first, so other sites cannot change the state through the browser of the user (CSRF).
+func (a *api) protect(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			h(w, r)
+			return
+		}
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("invalid or missing API token"))
+			return
+		}
+		if r.Method == http.MethodPost {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, errors.New("content type must be application/json"))
+				return
+			}
+		}
+		h(w, r)
+	}
+}
+
+// handleOverride sets (POST) or clears (DELETE) the manual override
+func (a *api) handleOverride(w http.ResponseWriter, r *http.Request) {
+	snap := a.current()
+	if snap.overrides == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("controller not ready"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		var req apiOverrideRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, apiBodyLimit)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+			return
+		}
+		duration := defaultOverrideFor
+		if req.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(req.Duration); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Errorf("invalid duration: %q", req.Duration))
+				return
+			}
+		}
+		mode, err := control.ParseMode(req.Mode)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if mode == control.EVUStop && !snap.evuRelay {
+			writeError(w, http.StatusBadRequest, errors.New("EVU STOP override requires EVU relay"))
+			return
+		}
+		o, err := snap.overrides.Set(mode, req.Boost, a.clock.Now(), duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		log.Info("override set over HTTP API", "mode", o.Mode, "boost", o.Boost, "until", o.Until)
+		notify(a.overrideChanged)
+		writeJSON(w, http.StatusOK, o)
+	case http.MethodDelete:
+		if err := snap.overrides.Clear(); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		log.Info("override cleared over HTTP API")
+		notify(a.overrideChanged)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	}
+}
+
+// handleReload requests configuration reload. The result is logged by the main loop.
+func (a *api) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	log.Info("configuration reload requested over HTTP API")
+	notify(a.reload)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleTemperature receives pushed indoor temperature (°C) as query parameter temp. Shelly H&T action URLs can only
+// send GET requests without headers, so the API token (if configured) is given as query parameter token.
+func (a *api) handleTemperature(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	if a.token != "" && subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(a.token)) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid or missing API token"))
+		return
+	}
+	push := a.current().push
+	if push == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.New("indoor temperature source is not push"))
+		return
+	}
+	t, err := strconv.ParseFloat(r.URL.Query().Get("temp"), 64)
+	if err != nil || math.IsNaN(t) || math.IsInf(t, 0) {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid temperature: %q", r.URL.Query().Get("temp")))
+		return
+	}
+	push.Push(t)
+	log.Debug("indoor temperature pushed over HTTP API", "temperature", t, "id", r.URL.Query().Get("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// notify sends to channel without blocking (a pending notification is enough)
+func notify(ch chan<- struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("failed to write HTTP response", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, apiError{Error: err.Error()})
+}
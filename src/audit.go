@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/audit"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+)
+
+// newAudit returns the audit log (nil when audit log is disabled)
+func newAudit(c config.Audit) *audit.Log {
+	if c.File == "" {
+		return nil
+	}
+	return audit.Open(c.File, time.Duration(c.RetentionDays)*24*time.Hour)
+}
+
+// auditRelays returns the relay states for the audit log (nothing when audit log is disabled)
+func (s state) auditRelays(ctx context.Context) ([]control.RelayStatus, error) {
+	if s.audit == nil {
+		return nil, nil
+	}
+	status, err := s.cs.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return status.Relays, nil
+}
+
+// record adds the control cycle to the audit log. Relay states after the cycle are read here.
+func (s state) record(ctx context.Context, now time.Time, d decision, before []control.RelayStatus, errs ...error) {
+	if s.audit == nil {
+		return
+	}
+	after, err := s.auditRelays(ctx)
+	errs = append(errs, err)
+
+	e := audit.Event{
+		Time:            now,
+		DryRun:          s.dryRun,
+		Strategy:        d.strategy,
+		Profile:         d.profile,
+		Constraints:     d.constraints,
+		Mode:            d.mode,
+		Boost:           d.boost,
+		FrostProtection: d.frostProtection,
+		Reason:          d.reason,
+		RelaysBefore:    before,
+		RelaysAfter:     after,
+	}
+	if price, err := s.sp.GetPrice(now); err == nil {
+		e.Price = &price
+	}
+	if s.outdoor.known {
+		e.Outdoor = &s.outdoor.current
+	}
+	if s.indoor.known {
+		e.Indoor = &s.indoor.temperature
+	}
+	for _, err := range errs {
+		if err != nil {
+			e.Errors = append(e.Errors, err.Error())
+		}
+	}
+	if err = s.audit.Append(e); err != nil {
+		log.Error("failed to record control cycle to audit log", "error", err)
+	}
+}
+
+// queryAudit prints the control cycles of a time range from the audit log
+func queryAudit(w io.Writer, l *audit.Log, timeRange string, loc *time.Location) error {
+	if l == nil {
+		return errors.New("audit log is disabled (AUDIT_FILE)")
+	}
+	from, to, err := parseTimeRange(timeRange, loc)
+	if err != nil {
+		return err
+	}
+	events, err := l.Query(from, to)
+	if err != nil {
+		return err
+	}
+	if len(events) == 0 {
+		fmt.Fprintf(w, "no control cycles recorded between %s and %s\n", from.Format(time.RFC822), to.Format(time.RFC822))
+		return nil
+	}
+	for _, e := range events {
+		printEvent(w, e, loc)
+	}
+	return nil
+}
+
+// printEvent prints a control cycle in human readable form
+func printEvent(w io.Writer, e audit.Event, loc *time.Location) {
+	mode := e.Mode.String()
+	if e.Boost {
+		mode += " + BOOST"
+	}
+	fmt.Fprintf(w, "%s  %s", e.Time.In(loc).Format("2006-01-02 15:04:05"), mode)
+	if e.Price != nil {
+		fmt.Fprintf(w, "  price: %.2f c/kWh", *e.Price)
+	}
+	if e.Outdoor != nil {
+		fmt.Fprintf(w, "  outdoor: %.1f", *e.Outdoor)
+	}
+	if e.Indoor != nil {
+		fmt.Fprintf(w, "  indoor: %.1f", *e.Indoor)
+	}
+	if e.DryRun {
+		fmt.Fprintf(w, "  (dry run)")
+	}
+	fmt.Fprintf(w, "\n  strategy: %s", e.Strategy)
+	if e.Profile != "" {
+		fmt.Fprintf(w, " (%s profile)", e.Profile)
+	}
+	fmt.Fprintf(w, "\n  reason: %s\n", e.Reason)
+	if len(e.Constraints) > 0 {
+		fmt.Fprintf(w, "  constraints: %s\n", strings.Join(e.Constraints, ", "))
+	}
+	if len(e.RelaysBefore) > 0 || len(e.RelaysAfter) > 0 {
+		fmt.Fprintf(w, "  relays: %s\n", relayChanges(e.RelaysBefore, e.RelaysAfter))
+	}
+	for _, err := range e.Errors {
+		fmt.Fprintf(w, "  error: %s\n", err)
+	}
+}
+
+// relayChanges formats relay states before and after a control cycle, e.g. "relay off -> on, boost off"
+func relayChanges(before, after []control.RelayStatus) string {
+	state := func(on bool) string {
+		if on {
+			return "on"
+		}
+		return "off"
+	}
+	var changes []string
+	for _, b := range before {
+		change := b.Name + " " + state(b.On)
+		for _, a := range after {
+			if a.Name == b.Name && a.On != b.On {
+				change += " -> " + state(a.On)
+			}
+		}
+		changes = append(changes, change)
+	}
+	if len(before) == 0 {
+		for _, a := range after {
+			changes = append(changes, a.Name+" ? -> "+state(a.On))
+		}
+	}
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/logging"
+)
+
+const (
+	pruneInterval = 24 * time.Hour
+	maxLineLength = 1 << 20
+)
+
+var log = logging.Component("audit")
+
+// Event is a control cycle: the inputs of the decision, the decision and the relay states before and after it was
+// applied
+type Event struct {
+	Time            time.Time             `json:"time"`
+	DryRun          bool                  `json:"dryRun,omitempty"`
+	Price           *float64              `json:"price,omitempty"`
+	Outdoor         *float64              `json:"outdoor,omitempty"`
+	Indoor          *float64              `json:"indoor,omitempty"`
+	Strategy        string                `json:"strategy"`
+	Profile         string                `json:"profile,omitempty"`
+	Constraints     []string              `json:"constraints,omitempty"` // constraints that changed the strategy decision
+	Mode            control.Mode          `json:"mode"`
+	Boost           bool                  `json:"boost"`
+	FrostProtection bool                  `json:"frostProtection,omitempty"`
+	Reason          string                `json:"reason"`
+	RelaysBefore    []control.RelayStatus `json:"relaysBefore,omitempty"`
+	RelaysAfter     []control.RelayStatus `json:"relaysAfter,omitempty"`
+	Errors          []string              `json:"errors,omitempty"`
+}
+
+// Log is an append-only audit log of control cycles in a JSON lines file. Events older than the retention period are
+// pruned once a day.
+type Log struct {
+	path      string
+	retention time.Duration
+	m         sync.Mutex
+	pruned    time.Time
+}
+
+// Open returns the audit log in path. Zero retention keeps the events forever.
+func Open(path string, retention time.Duration) *Log {
+	return &Log{path: path, retention: retention}
+}
+
+// Path returns the path of the audit log file
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Append adds an event to the log (and prunes old events once a day). Pruning errors are logged and the event is
+// appended anyway, pruning is tried again the next day.
+func (l *Log) Append(e Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.retention > 0 && e.Time.Sub(l.pruned) >= pruneInterval {
+		if err = l.prune(e.Time.Add(-l.retention)); err != nil {
+			log.Warn("failed to prune audit log", "error", err)
+		}
+		l.pruned = e.Time
+	}
+
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	// terminate a partial line (e.g. controller was killed while writing) so that this event is not lost
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err = f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			data = append([]byte{'\n'}, data...)
+		}
+	}
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	return f.Close()
+}
+
+// Query returns events from the time range [from, to) in the order they were recorded. Lines that cannot be parsed
+// (e.g. a partial line written when the controller was killed) are skipped.
+func (l *Log) Query(from, to time.Time) (events []Event, err error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	err = l.read(func(e Event) {
+		if !e.Time.Before(from) && e.Time.Before(to) {
+			events = append(events, e)
+		}
+	})
+	return events, err
+}
+
+// Prune removes events older than before
+func (l *Log) Prune(before time.Time) error {
+	l.m.Lock()
+	defer l.m.Unlock()
+	return l.prune(before)
+}
+
+func (l *Log) prune(before time.Time) error {
+	var kept []Event
+	removed := false
+	err := l.read(func(e Event) {
+		if e.Time.Before(before) {
+			removed = true
+			return
+		}
+		kept = append(kept, e)
+	})
+	if err != nil || !removed {
+		return err
+	}
+
+	// write to temporary file and rename, so that the log is never left half written
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, e := range kept {
+		if err = enc.Encode(e); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to prune audit log: %w", err)
+		}
+	}
+	if err = w.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), l.path); err != nil {
+		return fmt.Errorf("failed to prune audit log: %w", err)
+	}
+	return nil
+}
+
+// read calls f for every event in the log. Missing file is an empty log.
+func (l *Log) read(f func(Event)) error {
+	file, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxLineLength)
+	for scanner.Scan() {
+		var e Event
+		if json.Unmarshal(scanner.Bytes(), &e) != nil {
+			continue
+		}
+		f(e)
+	}
+	if err = scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/koovee/thermia/control"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	start := time.Date(2022, 10, 28, 0, 0, 0, 0, time.UTC)
+	l := Open(path, 3*24*time.Hour)
+
+	// no file yet
+	if events, err := l.Query(start, start.Add(time.Hour)); err != nil || len(events) != 0 {
+		t.Fatalf("Query() on missing file should return no events: %v, %v", events, err)
+	}
+
+	price := 12.5
+	for i := 0; i < 3*24; i++ {
+		e := Event{
+			Time:         start.Add(time.Duration(i) * time.Hour),
+			Price:        &price,
+			Strategy:     "threshold (10.00)",
+			Constraints:  []string{"indoor minimum"},
+			Mode:         control.Lowered,
+			Reason:       "price higher than the threshold",
+			RelaysBefore: []control.RelayStatus{{Name: "relay", On: false}},
+			RelaysAfter:  []control.RelayStatus{{Name: "relay", On: true}},
+		}
+		if err := l.Append(e); err != nil {
+			t.Fatalf("Append() did not succeed: %s", err.Error())
+		}
+	}
+
+	// partial line is skipped and does not break the next event
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %s", err.Error())
+	}
+	f.WriteString(`{"time":"2022-10-30T`)
+	f.Close()
+	if err = l.Append(Event{Time: start.Add(3 * 24 * time.Hour)}); err != nil {
+		t.Fatalf("Append() did not succeed: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		from     time.Time
+		to       time.Time
+		expected int
+	}{
+		"Hour":             {from: start.Add(48 * time.Hour), to: start.Add(49 * time.Hour), expected: 1},
+		"Day":              {from: start.Add(48 * time.Hour), to: start.Add(72 * time.Hour), expected: 24},
+		"Everything":       {from: start, to: start.Add(100 * time.Hour), expected: 3*24 + 1},
+		"Nothing recorded": {from: start.Add(-24 * time.Hour), to: start, expected: 0},
+	}
+	for k, c := range cases {
+		events, err := l.Query(c.from, c.to)
+		if err != nil {
+			t.Fatalf("%s: Query() did not succeed: %s", k, err.Error())
+		}
+		if len(events) != c.expected {
+			t.Errorf("%s: expected %d events, got %d", k, c.expected, len(events))
+		}
+	}
+
+	events, _ := l.Query(start.Add(7*time.Hour), start.Add(8*time.Hour))
+	e := events[0]
+	if *e.Price != price || e.Mode != control.Lowered || len(e.Constraints) != 1 || !e.RelaysAfter[0].On || e.RelaysBefore[0].On {
+		t.Errorf("event not restored: %+v", e)
+	}
+
+	// the first event a day after the previous prune removes events older than the retention
+	if err = l.Append(Event{Time: start.Add(4 * 24 * time.Hour)}); err != nil {
+		t.Fatalf("Append() did not succeed: %s", err.Error())
+	}
+	events, _ = l.Query(start, start.Add(100*time.Hour))
+	if len(events) != 50 || !events[0].Time.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("expected events of the last retention period, got %d events from %s", len(events), events[0].Time)
+	}
+}
+
+func TestAppendPruneFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	start := time.Date(2022, 10, 28, 0, 0, 0, 0, time.UTC)
+	l := Open(path, 24*time.Hour)
+
+	// a line longer than the maximum makes reading (and so pruning) fail
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", maxLineLength+1)+"\n"), 0o644); err != nil {
+		t.Fatalf("failed to write audit log: %s", err.Error())
+	}
+	for i, reason := range []string{"first", "second"} {
+		if err := l.Append(Event{Time: start.Add(time.Duration(i) * time.Hour), Reason: reason}); err != nil {
+			t.Fatalf("Append() should succeed although pruning fails: %s", err.Error())
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %s", err.Error())
+	}
+	if !strings.Contains(string(data), `"reason":"first"`) || !strings.Contains(string(data), `"reason":"second"`) {
+		t.Errorf("events were not appended")
+	}
+	if !l.pruned.Equal(start) {
+		t.Errorf("pruning should not be retried before the next day: %s", l.pruned)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/pricestore"
+	"github.com/koovee/thermia/spotprice"
+)
+
+const (
+	backfillChunkDays = 30          // days per request (ENTSO-E allows up to a year)
+	backfillDelay     = time.Second // between requests, to stay well below the request limit
+)
+
+// runBackfill fetches prices of the time range from the provider to the price store
+func runBackfill(ctx context.Context, w io.Writer, cfg config.Config, timeRange string) error {
+	if cfg.Prices.File == "" {
+		return errors.New("price store is disabled (PRICE_FILE)")
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	from, to, err := parseTimeRange(timeRange, loc)
+	if err != nil {
+		return err
+	}
+	store, err := pricestore.Open(cfg.Prices.File)
+	if err != nil {
+		return err
+	}
+	var sp spotprice.State
+	err = sp.InitWithConfig(spotprice.Config{Token: cfg.Provider.Token, Zone: cfg.Provider.Zone})
+	if err != nil {
+		return err
+	}
+	return backfill(ctx, w, store, sp, from, to, backfillChunkDays, backfillDelay)
+}
+
+// backfill fetches prices of the days between from and to that are not complete in the store. Days are fetched in
+// chunks of consecutive missing days and the store is saved after every chunk, so an interrupted backfill continues
+// where it stopped when run again (also after cancelling ctx).
+func backfill(ctx context.Context, w io.Writer, store *pricestore.Store, sp spotprice.State, from, to time.Time, chunkDays int, delay time.Duration) error {
+	chunks := missingDays(store, from, to, chunkDays)
+	if len(chunks) == 0 {
+		fmt.Fprintf(w, "prices between %s and %s are already stored\n", from.Format(time.DateOnly), to.Format(time.DateOnly))
+		return nil
+	}
+
+	for i, chunk := range chunks {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		start, end := chunk[0], chunk[1]
+		prices, err := sp.FetchRange(ctx, start, end)
+		if err != nil {
+			return fmt.Errorf("%s..%s: %w (run again to continue)", start.Format(time.DateOnly), end.Format(time.DateOnly), err)
+		}
+
+		days := make(map[string][]float64)
+		for _, p := range prices {
+			pricestore.SetHour(days, p.Time.In(start.Location()), p.Price)
+		}
+		complete := 0
+		for day, dayPrices := range days {
+			store.Set(day, dayPrices)
+			if len(dayPrices) == 24 {
+				complete++
+			}
+		}
+		if err = store.Save(); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s..%s: %d hours, %d complete days\n", start.Format(time.DateOnly), end.Format(time.DateOnly), len(prices), complete)
+	}
+	return nil
+}
+
+// missingDays returns ranges [start, end) of consecutive days between from and to that do not have prices for all
+// hours in the store. Ranges are at most chunkDays long.
+func missingDays(store *pricestore.Store, from, to time.Time, chunkDays int) (chunks [][2]time.Time) {
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if len(store.Day(day.Format(pricestore.DateLayout))) == 24 {
+			continue
+		}
+		last := len(chunks) - 1
+		if last >= 0 && chunks[last][1].Equal(day) && chunks[last][0].AddDate(0, 0, chunkDays).After(day) {
+			chunks[last][1] = day.AddDate(0, 0, 1)
+			continue
+		}
+		chunks = append(chunks, [2]time.Time{day, day.AddDate(0, 0, 1)})
+	}
+	return chunks
+}
@@ -0,0 +1,70 @@
+package building
+
+import (
+	"errors"
+	"math"
+)
+
+// Model is a simple first order thermal model of a building. Indoor temperature decays exponentially towards outdoor
+// temperature when heating is lowered: T(t) = Tout + (T0 - Tout) * exp(-t * HeatLoss / Capacity). When heating with
+// Power, indoor temperature approaches Tout + Power / HeatLoss in the same way.
+type Model struct {
+	HeatLoss float64 // heat loss coefficient (kW/K)
+	Capacity float64 // thermal capacity (kWh/K)
+	Power    float64 // heating power (kW)
+}
+
+// Validate returns error if model parameters are not valid
+func (m Model) Validate() error {
+	if m.HeatLoss <= 0 {
+		return errors.New("heat loss coefficient must be positive")
+	}
+	if m.Capacity <= 0 {
+		return errors.New("thermal capacity must be positive")
+	}
+	if m.Power <= 0 {
+		return errors.New("heating power must be positive")
+	}
+	return nil
+}
+
+// TimeConstant returns the time constant of the building in hours
+func (m Model) TimeConstant() float64 {
+	return m.Capacity / m.HeatLoss
+}
+
+// CoastHours returns how many hours indoor temperature stays within drop degrees from indoor temperature when
+// heating is lowered. Infinity is returned when outdoor temperature is not lower than the target temperature.
+func (m Model) CoastHours(indoor, outdoor, drop float64) float64 {
+	if drop <= 0 {
+		return 0
+	}
+	if indoor-drop <= outdoor {
+		return math.Inf(1)
+	}
+	return m.TimeConstant() * math.Log((indoor-outdoor)/(indoor-drop-outdoor))
+}
+
+// Temperature returns indoor temperature after hours of coasting
+func (m Model) Temperature(indoor, outdoor, hours float64) float64 {
+	return outdoor + (indoor-outdoor)*math.Exp(-hours/m.TimeConstant())
+}
+
+// StartTemperature returns the indoor temperature needed at the start of hours of coasting to have indoor temperature
+// end at the end of them
+func (m Model) StartTemperature(end, outdoor, hours float64) float64 {
+	return outdoor + (end-outdoor)*math.Exp(hours/m.TimeConstant())
+}
+
+// HeatingHours returns how many hours heating takes to raise indoor temperature to target. Infinity is returned when
+// heating power is not enough to reach target.
+func (m Model) HeatingHours(indoor, outdoor, target float64) float64 {
+	if target <= indoor {
+		return 0
+	}
+	equilibrium := outdoor + m.Power/m.HeatLoss
+	if equilibrium <= target {
+		return math.Inf(1)
+	}
+	return m.TimeConstant() * math.Log((equilibrium-indoor)/(equilibrium-target))
+}
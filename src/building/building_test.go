@@ -0,0 +1,84 @@
+package building
+
+import (
+	"math"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestValidate(t *testing.T) {
+	if err := (Model{HeatLoss: 0, Capacity: 10}).Validate(); err == nil {
+		t.Errorf("Validate() should have failed, but it succeeded")
+	}
+	if err := (Model{HeatLoss: 0.2, Capacity: -1}).Validate(); err == nil {
+		t.Errorf("Validate() should have failed, but it succeeded")
+	}
+	if err := (Model{HeatLoss: 0.2, Capacity: 10}).Validate(); err == nil {
+		t.Errorf("Validate() without heating power should have failed, but it succeeded")
+	}
+	if err := (Model{HeatLoss: 0.2, Capacity: 10, Power: 6}).Validate(); err != nil {
+		t.Errorf("Validate() did not succeed: %s", err.Error())
+	}
+}
+
+func TestCoastHours(t *testing.T) {
+	m := Model{HeatLoss: 0.2, Capacity: 10} // time constant 50 hours
+
+	cases := map[string]struct {
+		indoor         float64
+		outdoor        float64
+		drop           float64
+		expectedResult float64
+	}{
+		"Mild weather":           {indoor: 21, outdoor: 5, drop: 2, expectedResult: 50 * math.Log(16.0/14.0)},
+		"Cold weather":           {indoor: 21, outdoor: -25, drop: 2, expectedResult: 50 * math.Log(46.0/44.0)},
+		"No drop allowed":        {indoor: 21, outdoor: -25, drop: 0, expectedResult: 0},
+		"Outdoor warmer than in": {indoor: 21, outdoor: 20, drop: 2, expectedResult: math.Inf(1)},
+	}
+
+	for k, tc := range cases {
+		result := m.CoastHours(tc.indoor, tc.outdoor, tc.drop)
+		if math.Abs(result-tc.expectedResult) > 1e-9 && !(math.IsInf(result, 1) && math.IsInf(tc.expectedResult, 1)) {
+			t.Fatalf("%s: CoastHours\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+		if !math.IsInf(result, 1) && tc.drop > 0 {
+			if temperature := m.Temperature(tc.indoor, tc.outdoor, result); math.Abs(temperature-(tc.indoor-tc.drop)) > 1e-9 {
+				t.Fatalf("%s: Temperature after coasting\ngot:  %v\nwant: %v\n", k, temperature, tc.indoor-tc.drop)
+			}
+		}
+	}
+}
+
+func TestHeatingHours(t *testing.T) {
+	m := Model{HeatLoss: 0.2, Capacity: 4, Power: 6} // time constant 20 hours, heats up to 30 degrees above outdoor
+
+	cases := map[string]struct {
+		indoor         float64
+		outdoor        float64
+		target         float64
+		expectedResult float64
+	}{
+		"Mild weather":          {indoor: 21, outdoor: 0, target: 23, expectedResult: 20 * math.Log(9.0/7.0)},
+		"Already warm enough":   {indoor: 21, outdoor: 0, target: 20, expectedResult: 0},
+		"Not enough power":      {indoor: 19, outdoor: -10, target: 21, expectedResult: math.Inf(1)},
+		"Barely enough power":   {indoor: 19, outdoor: -10, target: 19.5, expectedResult: 20 * math.Log(1.0/0.5)},
+		"Target at equilibrium": {indoor: 21, outdoor: -10, target: 20, expectedResult: 0},
+	}
+
+	for k, tc := range cases {
+		result := m.HeatingHours(tc.indoor, tc.outdoor, tc.target)
+		if math.Abs(result-tc.expectedResult) > 1e-9 && !(math.IsInf(result, 1) && math.IsInf(tc.expectedResult, 1)) {
+			t.Fatalf("%s: HeatingHours\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+
+	// coasting from the start temperature ends at the given temperature
+	start := m.StartTemperature(19, -5, 3)
+	if temperature := m.Temperature(start, -5, 3); math.Abs(temperature-19) > 1e-9 {
+		t.Fatalf("Temperature after coasting from StartTemperature\ngot:  %v\nwant: %v\n", temperature, 19.0)
+	}
+}
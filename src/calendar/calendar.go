@@ -0,0 +1,260 @@
+package calendar
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/logging"
+)
+
+var log = logging.Component("calendar")
+
+const (
+	dateLayout     = "2006-01-02"
+	dateTimeLayout = "2006-01-02T15:04"
+
+	// maxRecurrenceYears limits how far yearly events without COUNT or UNTIL are repeated
+	maxRecurrenceYears = 100
+)
+
+// Period is a calendar period [From, To) during which a strategy profile is used
+type Period struct {
+	From    time.Time
+	To      time.Time
+	Profile string
+}
+
+// Calendar is a list of periods. The first matching period is used when periods overlap.
+type Calendar []Period
+
+// Profile returns the profile for a given time (empty if no period matches)
+func (c Calendar) Profile(t time.Time) string {
+	for _, p := range c {
+		if !t.Before(p.From) && t.Before(p.To) {
+			return p.Profile
+		}
+	}
+	return ""
+}
+
+// NextChange returns the next time after t when a period starts or ends. Zero time is returned if there is none.
+func (c Calendar) NextChange(t time.Time) (next time.Time) {
+	for _, p := range c {
+		for _, change := range []time.Time{p.From, p.To} {
+			if change.After(t) && (next.IsZero() || change.Before(next)) {
+				next = change
+			}
+		}
+	}
+	return next
+}
+
+// ParsePeriod parses a period from dates (YYYY-MM-DD, inclusive) or date and time (YYYY-MM-DDTHH:MM, exclusive).
+// Empty to is the same as from (single day).
+func ParsePeriod(from, to, profile string, loc *time.Location) (p Period, err error) {
+	if to == "" {
+		to = from
+	}
+	p.Profile = profile
+	p.From, _, err = parseTime(from, loc)
+	if err != nil {
+		return p, err
+	}
+	var date bool
+	p.To, date, err = parseTime(to, loc)
+	if err != nil {
+		return p, err
+	}
+	if date {
+		// the whole last day is included
+		p.To = p.To.AddDate(0, 0, 1)
+	}
+	if !p.To.After(p.From) {
+		return p, fmt.Errorf("period ends before it starts: %s - %s", from, to)
+	}
+	return p, nil
+}
+
+func parseTime(str string, loc *time.Location) (t time.Time, date bool, err error) {
+	t, err = time.ParseInLocation(dateLayout, str, loc)
+	if err == nil {
+		return t, true, nil
+	}
+	t, err = time.ParseInLocation(dateTimeLayout, str, loc)
+	if err != nil {
+		return t, false, fmt.Errorf("invalid date (YYYY-MM-DD or YYYY-MM-DDTHH:MM): %q", str)
+	}
+	return t, false, nil
+}
+
+// ParseICal parses events (VEVENT) from iCalendar data. Profile of the period is the lower case summary of the event.
+// Floating times and dates are in the given location. Yearly recurring events (RRULE with FREQ=YEARLY, INTERVAL,
+// COUNT and UNTIL) are repeated; other recurring events are logged and ignored. EXDATE and RDATE are not supported.
+func ParseICal(r io.Reader, loc *time.Location) (c Calendar, err error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			// folded line continues the previous one
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var p *Period
+	var hasEnd bool
+	var rrule string
+	for _, line := range lines {
+		name, params, value := parseLine(line)
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			p, hasEnd, rrule = &Period{}, false, ""
+		case name == "END" && value == "VEVENT":
+			if p == nil {
+				continue
+			}
+			if p.From.IsZero() {
+				return nil, fmt.Errorf("event without DTSTART")
+			}
+			if !hasEnd {
+				// event without end lasts the whole day
+				p.To = p.From.AddDate(0, 0, 1)
+			}
+			if rrule == "" {
+				c = append(c, *p)
+			} else if periods, err := repeatYearly(*p, rrule, loc); err != nil {
+				log.Warn("ignoring recurring event", "summary", p.Profile, "rrule", rrule, "error", err)
+			} else {
+				c = append(c, periods...)
+			}
+			p = nil
+		case p == nil:
+			continue
+		case name == "DTSTART":
+			p.From, err = parseICalTime(value, params, loc)
+			if err != nil {
+				return nil, err
+			}
+		case name == "DTEND":
+			p.To, err = parseICalTime(value, params, loc)
+			if err != nil {
+				return nil, err
+			}
+			hasEnd = true
+		case name == "SUMMARY":
+			p.Profile = strings.ToLower(strings.TrimSpace(value))
+		case name == "RRULE":
+			rrule = value
+		}
+	}
+	return c, nil
+}
+
+// repeatYearly returns the occurrences of a yearly recurring event. Only rules repeating the event on the date of
+// DTSTART are supported. Occurrences on February 29th are skipped in other than leap years.
+func repeatYearly(p Period, rrule string, loc *time.Location) (periods []Period, err error) {
+	var yearly bool
+	interval, count := 1, 0
+	var until time.Time
+	for _, part := range strings.Split(rrule, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid recurrence rule part: %q", part)
+		}
+		switch name, value := strings.ToUpper(kv[0]), kv[1]; name {
+		case "FREQ":
+			if strings.ToUpper(value) != "YEARLY" {
+				return nil, fmt.Errorf("unsupported frequency (only YEARLY is supported): %q", value)
+			}
+			yearly = true
+		case "INTERVAL", "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return nil, fmt.Errorf("invalid %s: %q", name, value)
+			}
+			if name == "INTERVAL" {
+				interval = n
+			} else {
+				count = n
+			}
+		case "UNTIL":
+			until, err = parseICalTime(value, nil, loc)
+			if err != nil {
+				return nil, err
+			}
+		case "BYMONTH":
+			if value != strconv.Itoa(int(p.From.Month())) {
+				return nil, fmt.Errorf("unsupported month: %q", value)
+			}
+		case "BYMONTHDAY":
+			if value != strconv.Itoa(p.From.Day()) {
+				return nil, fmt.Errorf("unsupported day of month: %q", value)
+			}
+		case "WKST":
+			// week start does not matter for yearly events on a fixed date
+		default:
+			return nil, fmt.Errorf("unsupported recurrence rule part: %q", part)
+		}
+	}
+	if !yearly {
+		return nil, fmt.Errorf("recurrence rule without FREQ")
+	}
+
+	for years := 0; years <= maxRecurrenceYears; years += interval {
+		if count > 0 && len(periods) == count {
+			break
+		}
+		from := p.From.AddDate(years, 0, 0)
+		if !until.IsZero() && from.After(until) {
+			break
+		}
+		if from.Day() != p.From.Day() {
+			// February 29th in other than leap year
+			continue
+		}
+		periods = append(periods, Period{From: from, To: p.To.AddDate(years, 0, 0), Profile: p.Profile})
+	}
+	return periods, nil
+}
+
+func parseLine(line string) (name string, params map[string]string, value string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return line, nil, ""
+	}
+	value = line[i+1:]
+	fields := strings.Split(line[:i], ";")
+	name = strings.ToUpper(fields[0])
+	params = make(map[string]string)
+	for _, f := range fields[1:] {
+		if kv := strings.SplitN(f, "=", 2); len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = kv[1]
+		}
+	}
+	return name, params, value
+}
+
+func parseICalTime(value string, params map[string]string, loc *time.Location) (time.Time, error) {
+	if tzid, ok := params["TZID"]; ok {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	switch {
+	case params["VALUE"] == "DATE" || len(value) == 8:
+		return time.ParseInLocation("20060102", value, loc)
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	default:
+		return time.ParseInLocation("20060102T150405", value, loc)
+	}
+}
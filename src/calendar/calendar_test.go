@@ -0,0 +1,161 @@
+package calendar
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testICal = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:1\r\n" +
+	"DTSTART;VALUE=DATE:20221224\r\n" +
+	"DTEND;VALUE=DATE:20221227\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:2\r\n" +
+	"DTSTART:20230102T080000Z\r\n" +
+	"DTEND;TZID=Europe/Helsinki:20230108T\r\n" +
+	" 180000\r\n" +
+	"SUMMARY:Away\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART:20230201\r\n" +
+	"SUMMARY:Skiing trip\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestParsePeriod(t *testing.T) {
+	loc := time.UTC
+
+	p, err := ParsePeriod("2022-12-20", "2022-12-27", "away", loc)
+	if err != nil {
+		t.Fatalf("ParsePeriod() did not succeed: %s", err.Error())
+	}
+	if !p.From.Equal(time.Date(2022, 12, 20, 0, 0, 0, 0, loc)) || !p.To.Equal(time.Date(2022, 12, 28, 0, 0, 0, 0, loc)) {
+		t.Fatalf("ParsePeriod() returned unexpected period: %v - %v", p.From, p.To)
+	}
+
+	p, err = ParsePeriod("2022-12-20T14:00", "2022-12-27T18:30", "away", loc)
+	if err != nil || !p.To.Equal(time.Date(2022, 12, 27, 18, 30, 0, 0, loc)) {
+		t.Fatalf("ParsePeriod() returned unexpected period: %v - %v (%v)", p.From, p.To, err)
+	}
+
+	p, err = ParsePeriod("2022-12-24", "", "holiday", loc)
+	if err != nil || p.To.Sub(p.From) != 24*time.Hour {
+		t.Fatalf("ParsePeriod() returned unexpected period: %v - %v (%v)", p.From, p.To, err)
+	}
+
+	invalid := [][]string{{"2022-12-27", "2022-12-20"}, {"27.12.2022", ""}, {"2022-12-20", "tomorrow"}}
+	for _, tc := range invalid {
+		if _, err := ParsePeriod(tc[0], tc[1], "away", loc); err == nil {
+			t.Errorf("ParsePeriod(%q, %q) should have failed, but it succeeded", tc[0], tc[1])
+		}
+	}
+}
+
+func TestParseICal(t *testing.T) {
+	helsinki, _ := time.LoadLocation("Europe/Helsinki")
+
+	c, err := ParseICal(strings.NewReader(testICal), helsinki)
+	if err != nil {
+		t.Fatalf("ParseICal() did not succeed: %s", err.Error())
+	}
+	if len(c) != 3 {
+		t.Fatalf("ParseICal() returned %d periods, want 3", len(c))
+	}
+
+	cases := map[string]struct {
+		time            time.Time
+		expectedProfile string
+	}{
+		"Before holiday":          {time: time.Date(2022, 12, 23, 23, 59, 0, 0, helsinki), expectedProfile: ""},
+		"Holiday":                 {time: time.Date(2022, 12, 24, 0, 0, 0, 0, helsinki), expectedProfile: "holiday"},
+		"Last day of holiday":     {time: time.Date(2022, 12, 26, 23, 0, 0, 0, helsinki), expectedProfile: "holiday"},
+		"After holiday":           {time: time.Date(2022, 12, 27, 0, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Away starts (UTC)":       {time: time.Date(2023, 1, 2, 10, 0, 0, 0, helsinki), expectedProfile: "away"},
+		"Away, before start":      {time: time.Date(2023, 1, 2, 9, 59, 0, 0, helsinki), expectedProfile: ""},
+		"Away ends (folded line)": {time: time.Date(2023, 1, 8, 18, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Event without end":       {time: time.Date(2023, 2, 1, 12, 0, 0, 0, helsinki), expectedProfile: "skiing trip"},
+	}
+
+	for k, tc := range cases {
+		profile := c.Profile(tc.time)
+		if profile != tc.expectedProfile {
+			t.Fatalf("%s: Profile\ngot:  %q\nwant: %q\n", k, profile, tc.expectedProfile)
+		}
+	}
+
+	next := c.NextChange(time.Date(2022, 12, 25, 0, 0, 0, 0, helsinki))
+	if !next.Equal(time.Date(2022, 12, 27, 0, 0, 0, 0, helsinki)) {
+		t.Fatalf("NextChange returned unexpected time: %v", next)
+	}
+}
+
+const testRecurringICal = "BEGIN:VCALENDAR\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20231206\r\n" +
+	"DTEND;VALUE=DATE:20231207\r\n" +
+	"RRULE:FREQ=YEARLY;BYMONTH=12;BYMONTHDAY=6\r\n" +
+	"SUMMARY:Holiday\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20240229\r\n" +
+	"RRULE:FREQ=YEARLY;COUNT=2\r\n" +
+	"SUMMARY:Leap day\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20230701\r\n" +
+	"DTEND;VALUE=DATE:20230708\r\n" +
+	"RRULE:FREQ=YEARLY;INTERVAL=2;UNTIL=20270701\r\n" +
+	"SUMMARY:Away\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"DTSTART;VALUE=DATE:20230101\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=SU\r\n" +
+	"SUMMARY:Sunday\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParseICalRecurrence(t *testing.T) {
+	helsinki, _ := time.LoadLocation("Europe/Helsinki")
+
+	c, err := ParseICal(strings.NewReader(testRecurringICal), helsinki)
+	if err != nil {
+		t.Fatalf("ParseICal() did not succeed: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		time            time.Time
+		expectedProfile string
+	}{
+		"First occurrence":                {time: time.Date(2023, 12, 6, 12, 0, 0, 0, helsinki), expectedProfile: "holiday"},
+		"Next year":                       {time: time.Date(2024, 12, 6, 12, 0, 0, 0, helsinki), expectedProfile: "holiday"},
+		"Decades later":                   {time: time.Date(2050, 12, 6, 12, 0, 0, 0, helsinki), expectedProfile: "holiday"},
+		"Day after":                       {time: time.Date(2024, 12, 7, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Before first occurrence":         {time: time.Date(2022, 12, 6, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Leap day":                        {time: time.Date(2024, 2, 29, 12, 0, 0, 0, helsinki), expectedProfile: "leap day"},
+		"No leap day":                     {time: time.Date(2025, 3, 1, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Second leap day":                 {time: time.Date(2028, 2, 29, 12, 0, 0, 0, helsinki), expectedProfile: "leap day"},
+		"After count":                     {time: time.Date(2032, 2, 29, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Every other year":                {time: time.Date(2025, 7, 3, 12, 0, 0, 0, helsinki), expectedProfile: "away"},
+		"Between intervals":               {time: time.Date(2024, 7, 3, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Until includes the start":        {time: time.Date(2027, 7, 3, 12, 0, 0, 0, helsinki), expectedProfile: "away"},
+		"After until":                     {time: time.Date(2029, 7, 3, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+		"Unsupported recurrence, ignored": {time: time.Date(2023, 1, 1, 12, 0, 0, 0, helsinki), expectedProfile: ""},
+	}
+
+	for k, tc := range cases {
+		profile := c.Profile(tc.time)
+		if profile != tc.expectedProfile {
+			t.Fatalf("%s: Profile\ngot:  %q\nwant: %q\n", k, profile, tc.expectedProfile)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/logging"
+)
+
+// command is a subcommand of the command line
+type command struct {
+	name    string
+	args    string // positional arguments and command specific flags for usage
+	summary string
+	run     func(ctx context.Context, fs *flag.FlagSet, args []string) error
+}
+
+var commands = []command{
+	{name: "run", args: "[-dryrun]", summary: "run the controller (default)", run: runCommand},
+	{name: "prices", args: "[-json]", summary: "print prices of today and tomorrow", run: pricesCommand},
+	{name: "plan", args: "[-json]", summary: "print planned modes for the rest of today and tomorrow", run: planCommand},
+	{name: "status", summary: "print relay states and manual override", run: statusCommand},
+	{name: "set", args: "on|off|normal|lowered|evustop|clear [-for 3h] [-boost]", summary: "set relays and manual override (relay on is LOWERED) or clear it", run: setCommand},
+	{name: "audit", args: "DATE|HOUR|RANGE", summary: "print control cycles from the audit log", run: auditCommand},
+	{name: "simulate", args: "[-prices file.csv] [-power kW] [-night HH:MM-HH:MM] [-day-price c/kWh -night-price c/kWh] DATE|RANGE", summary: "replay historical prices through the strategy", run: simulateCommand},
+	{name: "backfill", args: "DATE|RANGE", summary: "fetch historical prices to the price store", run: backfillCommand},
+	{name: "validate-config", summary: "validate configuration and exit", run: validateConfigCommand},
+	{name: "version", summary: "print version", run: versionCommand},
+}
+
+// usage prints the commands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: thermia [command] [-config thermia.yaml] [flags] [arguments]\n\nCommands:\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(tw, "  %s %s\t%s\n", c.name, c.args, c.summary)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\nRun 'thermia <command> -h' for the flags of a command.\n")
+}
+
+// execute runs the command named by the first argument ("run" when the first argument is a flag or missing, so that
+// 'thermia -dryrun' works as before)
+func execute(ctx context.Context, args []string) error {
+	name := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" || (name == "run" && len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help")) {
+		usage(os.Stdout)
+		return nil
+	}
+	for _, c := range commands {
+		if c.name == name {
+			fs := flag.NewFlagSet(name, flag.ExitOnError)
+			fs.Usage = func() {
+				fmt.Fprintf(fs.Output(), "Usage: thermia %s %s\n\n%s\n\nFlags:\n", c.name, c.args, c.summary)
+				fs.PrintDefaults()
+			}
+			return c.run(ctx, fs, args)
+		}
+	}
+	usage(os.Stderr)
+	return fmt.Errorf("unknown command %q", name)
+}
+
+// parseArgs parses flags placed before, between or after the positional arguments and returns the positional
+// arguments. Exactly n positional arguments are required.
+func parseArgs(fs *flag.FlagSet, args []string, n int) (positional []string, err error) {
+	for {
+		if err = fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != n {
+		fs.Usage()
+		return nil, fmt.Errorf("expected %d argument(s), got %d", n, len(positional))
+	}
+	return positional, nil
+}
+
+// configFlag adds the configuration file flag shared by all commands
+func configFlag(fs *flag.FlagSet) *string {
+	return fs.String("config", os.Getenv("CONFIG_FILE"), "configuration file (YAML)")
+}
+
+// loadConfig loads the configuration and sets up logging to w
+func loadConfig(path string, w io.Writer) (cfg config.Config, err error) {
+	cfg, err = config.Load(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err = logging.Setup(w, cfg.Log.Level, cfg.Log.Format); err != nil {
+		return cfg, fmt.Errorf("failed to set up logging: %w", err)
+	}
+	return cfg, nil
+}
+
+func runCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	dryRun := fs.Bool("dryrun", false, "disable relay control")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stdout)
+	if err != nil {
+		return err
+	}
+	return runController(ctx, *configFile, cfg, *dryRun)
+}
+
+func pricesCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	jsonOutput := fs.Bool("json", false, "print prices as JSON")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	// output is kept on stdout, e.g. for JSON
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return runPrices(ctx, os.Stdout, cfg, *jsonOutput)
+}
+
+func planCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	jsonOutput := fs.Bool("json", false, "print plan as JSON")
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return runPlan(ctx, os.Stdout, cfg, *jsonOutput)
+}
+
+func statusCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	var cs control.State
+	err = cs.InitWithConfig(control.Config{URL: cfg.Relays.URL, BoostURL: cfg.Relays.BoostURL, EVUURL: cfg.Relays.EVUURL}, true)
+	if err != nil {
+		return err
+	}
+	status, err := cs.Status(ctx)
+	if err != nil {
+		return err
+	}
+	printRelayStatus(os.Stdout, status)
+	if o, ok := newOverrides(cfg.Override.File).Active(clock.Real.Now()); ok {
+		fmt.Printf("override: %s (boost: %v) until %s", o.Mode, o.Boost, o.Until.Format(time.RFC822))
+		if o.Source != "" {
+			fmt.Printf(" (changed on the relay by %s)", o.Source)
+		}
+		fmt.Printf("\n")
+	}
+	return nil
+}
+
+// printRelayStatus prints the operating mode and the relays
+func printRelayStatus(w io.Writer, status control.Status) {
+	mode := status.Mode.String()
+	if status.Boost {
+		mode += " + BOOST"
+	}
+	fmt.Fprintf(w, "mode: %s\n", mode)
+	for _, r := range status.Relays {
+		state := "off"
+		if r.On {
+			state = "on"
+		}
+		fmt.Fprintf(w, "%s: %s", r.Name, state)
+		if r.Source != "" {
+			fmt.Fprintf(w, " (changed by %s)", r.Source)
+		}
+		fmt.Fprintf(w, "\n")
+	}
+}
+
+func setCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	duration := fs.Duration("for", 3*time.Hour, "duration of the manual override")
+	boost := fs.Bool("boost", false, "turn boost relay on during the manual override (normal mode only)")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	// on and off are the states of the relay: relay on lowers the room temperature
+	mode := map[string]string{"on": "lowered", "off": "normal"}[positional[0]]
+	if mode == "" {
+		mode = positional[0]
+	}
+	store := newOverrides(cfg.Override.File)
+	store.SetBoostRelay(cfg.Relays.BoostURL != "")
+	// running controller notices the change in the override file
+	o, err := setOverride(store, mode, *boost, clock.Real.Now(), *duration, cfg.Relays.EVUURL != "")
+	if err != nil || mode == "clear" {
+		return err
+	}
+
+	// relays are set here too, so that the override takes effect also when the controller is not running. Frost
+	// protection is checked with the temperatures read now, because nothing checks it later without the controller.
+	d, err := applyFrostProtectionNow(ctx, cfg, decision{mode: o.Mode, boost: o.Boost, reason: "manual override"})
+	if err != nil {
+		return err
+	}
+	var cs control.State
+	err = cs.InitWithConfig(control.Config{URL: cfg.Relays.URL, BoostURL: cfg.Relays.BoostURL, EVUURL: cfg.Relays.EVUURL}, false)
+	if err != nil {
+		return err
+	}
+	if err = cs.Set(ctx, d.mode); err != nil {
+		return fmt.Errorf("override is set, but setting the relays failed: %w", err)
+	}
+	if err = cs.SetBoost(ctx, d.boost); err != nil {
+		return fmt.Errorf("override is set, but setting the boost relay failed: %w", err)
+	}
+	if d.frostProtection {
+		fmt.Printf("relays set to %s: %s\n", d.mode, d.reason)
+	} else {
+		fmt.Printf("relays set to %s\n", d.mode)
+	}
+	fmt.Printf("override expires only while the controller is running (thermia run)\n")
+	return nil
+}
+
+func auditCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	return queryAudit(os.Stdout, newAudit(cfg.Audit), positional[0], loc)
+}
+
+func simulateCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	prices := fs.String("prices", "", "price file (CSV: time,price EUR/MWh), default is the price store")
+	power := fs.Float64("power", 2, "power (kW) of the heat pump when heating, for simulated costs")
+	night := fs.String("night", defaultNightWindow, "night tariff window for the baseline")
+	dayPrice := fs.Float64("day-price", 0, "day tariff total price (c/kWh) for the baseline, requires -night-price")
+	nightPrice := fs.Float64("night-price", 0, "night tariff total price (c/kWh) for the baseline, requires -day-price")
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return runSimulation(os.Stdout, cfg, positional[0], *prices, *power, tariff{nightWindow: *night, dayPrice: *dayPrice, nightPrice: *nightPrice})
+}
+
+func backfillCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	positional, err := parseArgs(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(*configFile, os.Stderr)
+	if err != nil {
+		return err
+	}
+	return runBackfill(ctx, os.Stdout, cfg, positional[0])
+}
+
+func validateConfigCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	configFile := configFlag(fs)
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	if _, err := config.Load(*configFile); err != nil {
+		return err
+	}
+	fmt.Printf("configuration is valid\n")
+	return nil
+}
+
+func versionCommand(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	if _, err := parseArgs(fs, args, 0); err != nil {
+		return err
+	}
+	v := version
+	if v == "" {
+		v = "unknown"
+	}
+	fmt.Printf("thermia %s\n", v)
+	return nil
+}
+
+// runPrices prints total prices (c/kWh) of today and tomorrow. Prices are read from the price store and fetched from
+// the provider when missing.
+func runPrices(ctx context.Context, w io.Writer, cfg config.Config, jsonOutput bool) error {
+	s, err := newState(cfg, true, clock.Real, nil)
+	if err != nil {
+		return err
+	}
+	s.sp.UpdateSpotPrices(ctx)
+	s.storePrices()
+
+	now := s.clock.Now()
+	var prices []apiPrice
+	for day := 0; day < 2; day++ {
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+day, 0, 0, 0, 0, now.Location())
+		for hour, price := range s.sp.DayPrices(midnight) {
+			t := time.Date(midnight.Year(), midnight.Month(), midnight.Day(), hour, 0, 0, 0, midnight.Location())
+			prices = append(prices, apiPrice{Time: t, Price: price})
+		}
+	}
+	if jsonOutput {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(prices)
+	}
+	if len(prices) == 0 {
+		return errors.New("no prices available")
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Time\tPrice (c/kWh)\t\n")
+	for _, p := range prices {
+		fmt.Fprintf(tw, "%s\t%.2f\t\n", p.Time.Format("2006-01-02 15:04"), p.Price)
+	}
+	tw.Flush()
+	return nil
+}
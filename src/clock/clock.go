@@ -0,0 +1,64 @@
+package clock
+
+import "time"
+
+// Clock tells the current time and creates timers. Real clock is used in production and a fake clock in tests, so
+// that scheduling can be tested without waiting.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer sends the current time on its channel when it fires
+type Timer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration) bool
+	Stop() bool
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+// InLocation returns a clock that tells the time of c in loc. The controller uses the configured timezone this way
+// instead of changing time.Local, which other goroutines read at the same time.
+func InLocation(c Clock, loc *time.Location) Clock {
+	if l, ok := c.(locationClock); ok {
+		c = l.Clock
+	}
+	return locationClock{Clock: c, loc: loc}
+}
+
+type locationClock struct {
+	Clock
+	loc *time.Location
+}
+
+func (c locationClock) Now() time.Time {
+	return c.Clock.Now().In(c.loc)
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.t.C
+}
+
+func (t realTimer) Reset(d time.Duration) bool {
+	return t.t.Reset(d)
+}
+
+func (t realTimer) Stop() bool {
+	return t.t.Stop()
+}
@@ -0,0 +1,90 @@
+package clock
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestFake(t *testing.T) {
+	start := time.Date(2022, 12, 1, 17, 30, 0, 0, time.UTC)
+	f := NewFake(start)
+	timer := f.NewTimer(30 * time.Minute)
+
+	fired := func() bool {
+		select {
+		case <-timer.C():
+			return true
+		default:
+			return false
+		}
+	}
+
+	cases := []struct {
+		name          string
+		advance       time.Duration
+		expectedFired bool
+	}{
+		{name: "Before deadline", advance: 29 * time.Minute, expectedFired: false},
+		{name: "At deadline", advance: time.Minute, expectedFired: true},
+		{name: "Fires only once", advance: time.Hour, expectedFired: false},
+	}
+	for _, c := range cases {
+		f.Advance(c.advance)
+		if fired() != c.expectedFired {
+			t.Fatalf("%s: expected fired to be %v", c.name, c.expectedFired)
+		}
+	}
+	if !f.Now().Equal(start.Add(90 * time.Minute)) {
+		t.Errorf("unexpected time: %s", f.Now())
+	}
+
+	// reset timer is waited for
+	timer.Reset(time.Hour)
+	f.BlockUntil(1)
+	if next := f.Next(); !next.Equal(start.Add(150 * time.Minute)) {
+		t.Errorf("unexpected next deadline: %s", next)
+	}
+	if !timer.Stop() || timer.Stop() {
+		t.Errorf("Stop() should report whether the timer was active")
+	}
+	f.Advance(2 * time.Hour)
+	if fired() {
+		t.Errorf("stopped timer should not fire")
+	}
+
+	// timer waited in another goroutine
+	done := make(chan time.Time)
+	go func() {
+		timer := f.NewTimer(time.Second)
+		done <- <-timer.C()
+	}()
+	f.BlockUntil(1)
+	f.Advance(time.Second)
+	if now := <-done; !now.Equal(f.Now()) {
+		t.Errorf("timer sent unexpected time: %s", now)
+	}
+}
+
+func TestInLocation(t *testing.T) {
+	helsinki, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Fatalf("failed to load location: %s", err.Error())
+	}
+	start := time.Date(2022, 12, 1, 22, 30, 0, 0, time.UTC)
+	f := NewFake(start)
+
+	// wrapping again replaces the location
+	c := InLocation(InLocation(f, time.UTC), helsinki)
+	if now := c.Now(); now.Location() != helsinki || now.Hour() != 0 || !now.Equal(start) {
+		t.Fatalf("unexpected time: %s", now)
+	}
+	f.Advance(time.Hour)
+	if now := c.Now(); now.Hour() != 1 {
+		t.Fatalf("clock did not follow the wrapped clock: %s", now)
+	}
+}
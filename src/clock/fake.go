@@ -0,0 +1,129 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock that moves only when advanced. Timers fire when the clock is advanced past their deadline.
+type Fake struct {
+	m      sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFake returns a fake clock set to now
+func NewFake(now time.Time) *Fake {
+	f := &Fake{now: now}
+	f.cond = sync.NewCond(&f.m)
+	return f
+}
+
+// Now returns the time of the fake clock
+func (f *Fake) Now() time.Time {
+	f.m.Lock()
+	defer f.m.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires when the clock is advanced by d
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{f: f, c: make(chan time.Time, 1)}
+	f.m.Lock()
+	f.timers = append(f.timers, t)
+	f.m.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the clock forward and fires the timers whose deadline has passed
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the clock to t and fires the timers whose deadline has passed
+func (f *Fake) Set(t time.Time) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.now = t
+	for _, timer := range f.timers {
+		if timer.active && !timer.deadline.After(t) {
+			timer.fire(t)
+		}
+	}
+	f.cond.Broadcast()
+}
+
+// Next returns the deadline of the earliest waiting timer (zero if no timer is waiting)
+func (f *Fake) Next() (next time.Time) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for _, timer := range f.timers {
+		if timer.active && (next.IsZero() || timer.deadline.Before(next)) {
+			next = timer.deadline
+		}
+	}
+	return next
+}
+
+// BlockUntil waits until n timers are waiting (e.g. the code under test has finished its work and waits for the next
+// timer)
+func (f *Fake) BlockUntil(n int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	for f.waiting() < n {
+		f.cond.Wait()
+	}
+}
+
+func (f *Fake) waiting() (n int) {
+	for _, timer := range f.timers {
+		if timer.active {
+			n++
+		}
+	}
+	return n
+}
+
+type fakeTimer struct {
+	f        *Fake
+	c        chan time.Time
+	deadline time.Time
+	active   bool
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.f.m.Lock()
+	defer t.f.m.Unlock()
+	wasActive := t.active
+	t.deadline = t.f.now.Add(d)
+	t.active = true
+	if d <= 0 {
+		t.fire(t.f.now)
+	}
+	t.f.cond.Broadcast()
+	return wasActive
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.f.m.Lock()
+	defer t.f.m.Unlock()
+	wasActive := t.active
+	t.active = false
+	t.f.cond.Broadcast()
+	return wasActive
+}
+
+// fire sends the time to the channel (a pending value is enough, like with time.Timer)
+func (t *fakeTimer) fire(now time.Time) {
+	t.active = false
+	select {
+	case t.c <- now:
+	default:
+	}
+}
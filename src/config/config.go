@@ -0,0 +1,514 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/calendar"
+	"github.com/koovee/thermia/schedule"
+	"github.com/koovee/thermia/temperature"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ProviderEntsoe = "entsoe"
+
+	WindowDay     = "day"
+	WindowRolling = "rolling"
+
+	SourceHTTP   = "http"
+	SourceFMI    = "fmi"
+	SourceShelly = "shelly"
+	SourceMQTT   = "mqtt"
+	SourcePush   = "push"
+)
+
+// Config is the controller configuration. It is loaded from an optional YAML file and environment variables override
+// the values from the file.
+type Config struct {
+	Timezone string             `yaml:"timezone"`
+	Provider Provider           `yaml:"provider"`
+	Pricing  Pricing            `yaml:"pricing"`
+	Strategy Strategy           `yaml:"strategy"`
+	Schedule []string           `yaml:"schedule"`
+	Forced   Forced             `yaml:"forced"`
+	Relays   Relays             `yaml:"relays"`
+	Outdoor  Outdoor            `yaml:"outdoor"`
+	Indoor   Indoor             `yaml:"indoor"`
+	Frost    Frost              `yaml:"frost"`
+	Building Building           `yaml:"building"`
+	Profiles map[string]Profile `yaml:"profiles"`
+	Calendar Calendar           `yaml:"calendar"`
+	Override Override           `yaml:"override"`
+	API      API                `yaml:"api"`
+	Log      Log                `yaml:"log"`
+	Audit    Audit              `yaml:"audit"`
+	Prices   Prices             `yaml:"prices"`
+	Shutdown Shutdown           `yaml:"shutdown"`
+}
+
+// Provider is the spot price provider
+type Provider struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+	Zone  string `yaml:"zone"`
+}
+
+// Pricing converts spot price to total price: spot * (1 + VAT/100) + margin + transfer (c/kWh). VAT is not added to
+// negative spot prices.
+type Pricing struct {
+	VAT      float64 `yaml:"vat"`
+	Margin   float64 `yaml:"margin"`
+	Transfer float64 `yaml:"transfer"`
+}
+
+// Strategy selects and configures the control strategy
+type Strategy struct {
+	Threshold   float64  `yaml:"threshold"`
+	MaxPrice    float64  `yaml:"maxPrice"`
+	ActiveHours int      `yaml:"activeHours"`
+	BlockHours  []int    `yaml:"blockHours"`
+	Relative    Relative `yaml:"relative"`
+	AlwaysOn    AlwaysOn `yaml:"alwaysOn"`
+	Preheat     Preheat  `yaml:"preheat"`
+}
+
+// Relative threshold is either percentile of the prices or percentage above the median price
+type Relative struct {
+	Percentile *float64 `yaml:"percentile"`
+	Median     *float64 `yaml:"median"`
+	Window     string   `yaml:"window"`
+	Floor      float64  `yaml:"floor"`
+}
+
+// AlwaysOn forces heating ON when price is lower than Price
+type AlwaysOn struct {
+	Price *float64 `yaml:"price"`
+	Boost bool     `yaml:"boost"`
+}
+
+// Preheat configures pre-heating before price spikes (requires building model)
+type Preheat struct {
+	Hours  int     `yaml:"hours"`
+	Drop   float64 `yaml:"drop"`
+	Spread float64 `yaml:"spread"`
+}
+
+// Forced windows override price strategies (forced on wins when windows overlap)
+type Forced struct {
+	On  []string `yaml:"on"`
+	Off []string `yaml:"off"`
+}
+
+// Relays are Shelly relay URLs
+type Relays struct {
+	URL      string `yaml:"url"`
+	BoostURL string `yaml:"boostUrl"`
+	EVUURL   string `yaml:"evuUrl"`
+	// ReconcileInterval is the interval of checking that relays are in the decided state (0 disables)
+	ReconcileInterval time.Duration `yaml:"reconcileInterval"`
+	// RespectManual keeps manual changes made on the relay (button or Shelly app) as a temporary override until the
+	// next control cycle or ManualTimeout (if set)
+	RespectManual bool          `yaml:"respectManual"`
+	ManualTimeout time.Duration `yaml:"manualTimeout"`
+}
+
+// Outdoor temperature source and heating curve
+type Outdoor struct {
+	Source        string `yaml:"source"`
+	URL           string `yaml:"url"`
+	Place         string `yaml:"place"`
+	ForecastHours int    `yaml:"forecastHours"`
+	HeatingCurve  string `yaml:"heatingCurve"`
+}
+
+// Indoor temperature source and comfort limits
+type Indoor struct {
+	Source     string        `yaml:"source"`
+	URL        string        `yaml:"url"`
+	MQTTBroker string        `yaml:"mqttBroker"`
+	MQTTTopic  string        `yaml:"mqttTopic"`
+	MaxAge     time.Duration `yaml:"maxAge"` // older temperatures are not used (0 disables the check)
+	Min        *float64      `yaml:"min"`
+	Max        *float64      `yaml:"max"`
+}
+
+// Frost protection limits
+type Frost struct {
+	OutdoorLimit *float64 `yaml:"outdoorLimit"`
+	IndoorLimit  float64  `yaml:"indoorLimit"`
+}
+
+// Building model (heat loss coefficient kW/K, thermal capacity kWh/K, heating power kW)
+type Building struct {
+	HeatLoss     float64 `yaml:"heatLoss"`
+	Capacity     float64 `yaml:"capacity"`
+	HeatingPower float64 `yaml:"heatingPower"`
+}
+
+// Profile is a strategy profile used during calendar periods. Its strategy replaces the main strategy.
+type Profile struct {
+	Strategy    Strategy `yaml:"strategy"`
+	EVUStop     bool     `yaml:"evuStop"`
+	ReturnHours int      `yaml:"returnHours"`
+}
+
+// Calendar lists periods (e.g. away or holiday) during which a strategy profile is used. Events of the optional
+// iCalendar file use the profile named by the event summary or DefaultProfile.
+type Calendar struct {
+	File           string   `yaml:"file"`
+	DefaultProfile string   `yaml:"defaultProfile"`
+	Periods        []Period `yaml:"periods"`
+}
+
+// Period is a calendar period. From and To are dates (YYYY-MM-DD, inclusive) or date and time (YYYY-MM-DDTHH:MM).
+type Period struct {
+	From    string `yaml:"from"`
+	To      string `yaml:"to"`
+	Profile string `yaml:"profile"`
+}
+
+// Override is the manual override persistence
+type Override struct {
+	File string `yaml:"file"`
+}
+
+// API is the HTTP status and control API
+type API struct {
+	Listen string `yaml:"listen"`
+	Token  string `yaml:"token"` // required in the Authorization header of the requests that change the state
+}
+
+// Log is the log level (debug, info, warn or error) and format (text or json)
+type Log struct {
+	Level  string `yaml:"level"`
+	Format string `yaml:"format"`
+}
+
+// Audit is the audit log of control cycles. Events older than RetentionDays are removed (0 keeps them forever).
+type Audit struct {
+	File          string `yaml:"file"`
+	RetentionDays int    `yaml:"retentionDays"`
+}
+
+// Prices is the persistent store of fetched spot prices (used for simulation)
+type Prices struct {
+	File string `yaml:"file"`
+}
+
+// Shutdown is the relay mode set when the controller stops: normal, lowered or keep (relays are left as they are)
+type Shutdown struct {
+	Mode string `yaml:"mode"`
+}
+
+// ShutdownKeep leaves relays as they are when the controller stops
+const ShutdownKeep = "keep"
+
+// Problems is a list of configuration problems
+type Problems []string
+
+func (p Problems) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(p, "\n  - ")
+}
+
+// Default returns the default configuration
+func Default() Config {
+	return Config{
+		Timezone: "Europe/Helsinki",
+		Provider: Provider{Name: ProviderEntsoe, Zone: "10YFI-1--------U"},
+		Strategy: Strategy{
+			Relative: Relative{Window: WindowDay},
+			Preheat:  Preheat{Hours: 2, Drop: 2, Spread: 5},
+		},
+		Schedule: []string{"0", "1", "2", "3", "4", "5"},
+		Outdoor:  Outdoor{ForecastHours: 24},
+		Indoor:   Indoor{MaxAge: time.Hour},
+		Frost:    Frost{IndoorLimit: 5},
+		Calendar: Calendar{DefaultProfile: "away"},
+		Override: Override{File: "override.json"},
+		Log:      Log{Level: "info", Format: "text"},
+		Audit:    Audit{File: "audit.jsonl", RetentionDays: 90},
+		Prices:   Prices{File: "prices.json"},
+		Shutdown: Shutdown{Mode: "normal"},
+	}
+}
+
+// Load loads configuration from file (optional, empty path skips the file), applies environment variable overrides
+// and validates the result. All problems are returned at once.
+func Load(path string) (c Config, err error) {
+	c = Default()
+
+	if path != "" {
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err != nil {
+			return c, fmt.Errorf("failed to read configuration file: %w", err)
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err = decoder.Decode(&c); err != nil && !errors.Is(err, io.EOF) {
+			return c, fmt.Errorf("failed to parse configuration file (%s): %w", path, err)
+		}
+	}
+
+	for name, profile := range c.Profiles {
+		profile.Strategy.setDefaults()
+		c.Profiles[name] = profile
+	}
+
+	problems := applyEnv(&c)
+	problems = append(problems, c.Validate()...)
+	if len(problems) > 0 {
+		return c, problems
+	}
+	return c, nil
+}
+
+// Validate returns all problems in the configuration
+func (c Config) Validate() (p Problems) {
+	if _, err := time.LoadLocation(c.Timezone); err != nil {
+		p = append(p, fmt.Sprintf("timezone: invalid timezone %q", c.Timezone))
+	}
+
+	if c.Provider.Name != ProviderEntsoe {
+		p = append(p, fmt.Sprintf("provider.name: unknown provider %q (entsoe)", c.Provider.Name))
+	}
+	if c.Provider.Token == "" {
+		p = append(p, "provider.token: required (TOKEN)")
+	}
+	if c.Provider.Zone == "" {
+		p = append(p, "provider.zone: required (PRICE_ZONE)")
+	}
+
+	if c.Pricing.VAT < 0 || c.Pricing.VAT > 100 {
+		p = append(p, fmt.Sprintf("pricing.vat: must be between 0 and 100 (%v)", c.Pricing.VAT))
+	}
+
+	p = append(p, validateStrategy("strategy", c.Strategy)...)
+
+	p = append(p, validateSchedule("schedule", c.Schedule)...)
+	p = append(p, validateSchedule("forced.on", c.Forced.On)...)
+	p = append(p, validateSchedule("forced.off", c.Forced.Off)...)
+
+	if c.Relays.URL == "" {
+		p = append(p, "relays.url: required (SHELLY_URL)")
+	}
+	p = append(p, validateURL("relays.url", c.Relays.URL)...)
+	p = append(p, validateURL("relays.boostUrl", c.Relays.BoostURL)...)
+	p = append(p, validateURL("relays.evuUrl", c.Relays.EVUURL)...)
+	if c.Strategy.AlwaysOn.Boost && c.Relays.BoostURL == "" {
+		p = append(p, "strategy.alwaysOn.boost: requires relays.boostUrl")
+	}
+
+	switch c.Outdoor.Source {
+	case "":
+		if c.Outdoor.HeatingCurve != "" {
+			p = append(p, "outdoor.heatingCurve: requires outdoor.source")
+		}
+		if c.Frost.OutdoorLimit != nil {
+			p = append(p, "frost.outdoorLimit: requires outdoor.source")
+		}
+	case SourceHTTP:
+		if c.Outdoor.URL == "" {
+			p = append(p, "outdoor.url: required with http source (OUTDOOR_URL)")
+		}
+	case SourceFMI:
+		if c.Outdoor.Place == "" {
+			p = append(p, "outdoor.place: required with fmi source (OUTDOOR_PLACE)")
+		}
+	default:
+		p = append(p, fmt.Sprintf("outdoor.source: must be http or fmi (%q)", c.Outdoor.Source))
+	}
+	p = append(p, validateURL("outdoor.url", c.Outdoor.URL)...)
+	if c.Outdoor.HeatingCurve != "" {
+		if _, err := temperature.ParseCurve(c.Outdoor.HeatingCurve); err != nil {
+			p = append(p, fmt.Sprintf("outdoor.heatingCurve: %s", err.Error()))
+		}
+	}
+	if c.Outdoor.ForecastHours < 0 {
+		p = append(p, fmt.Sprintf("outdoor.forecastHours: must not be negative (%d)", c.Outdoor.ForecastHours))
+	}
+
+	switch c.Indoor.Source {
+	case "":
+		if c.Indoor.Min != nil || c.Indoor.Max != nil {
+			p = append(p, "indoor.min/max: requires indoor.source")
+		}
+	case SourceHTTP, SourceShelly:
+		if c.Indoor.URL == "" {
+			p = append(p, fmt.Sprintf("indoor.url: required with %s source (INDOOR_URL)", c.Indoor.Source))
+		}
+	case SourceMQTT:
+		if c.Indoor.MQTTBroker == "" {
+			p = append(p, "indoor.mqttBroker: required with mqtt source (INDOOR_MQTT_BROKER)")
+		}
+		if c.Indoor.MQTTTopic == "" {
+			p = append(p, "indoor.mqttTopic: required with mqtt source (INDOOR_MQTT_TOPIC)")
+		}
+	case SourcePush:
+		if c.API.Listen == "" {
+			p = append(p, "indoor.source: push source requires api.listen (API_LISTEN)")
+		}
+	default:
+		p = append(p, fmt.Sprintf("indoor.source: must be http, shelly, mqtt or push (%q)", c.Indoor.Source))
+	}
+	if c.Indoor.MaxAge < 0 {
+		p = append(p, fmt.Sprintf("indoor.maxAge: must not be negative (%s)", c.Indoor.MaxAge))
+	}
+	p = append(p, validateURL("indoor.url", c.Indoor.URL)...)
+	if c.Indoor.Min != nil && c.Indoor.Max != nil && *c.Indoor.Min >= *c.Indoor.Max {
+		p = append(p, fmt.Sprintf("indoor.min: must be lower than indoor.max (%v >= %v)", *c.Indoor.Min, *c.Indoor.Max))
+	}
+
+	for _, name := range c.profileNames() {
+		profile := c.Profiles[name]
+		field := fmt.Sprintf("profiles.%s", name)
+		p = append(p, validateStrategy(field+".strategy", profile.Strategy)...)
+		if profile.EVUStop && c.Relays.EVUURL == "" {
+			p = append(p, field+".evuStop: requires relays.evuUrl")
+		}
+		if profile.Strategy.AlwaysOn.Boost && c.Relays.BoostURL == "" {
+			p = append(p, field+".strategy.alwaysOn.boost: requires relays.boostUrl")
+		}
+		if profile.ReturnHours < 0 {
+			p = append(p, fmt.Sprintf("%s.returnHours: must not be negative (%d)", field, profile.ReturnHours))
+		}
+	}
+	loc, locErr := time.LoadLocation(c.Timezone)
+	for _, period := range c.Calendar.Periods {
+		if _, ok := c.Profiles[period.Profile]; !ok {
+			p = append(p, fmt.Sprintf("calendar.periods: unknown profile %q", period.Profile))
+		}
+		if locErr != nil {
+			continue
+		}
+		if _, err := calendar.ParsePeriod(period.From, period.To, period.Profile, loc); err != nil {
+			p = append(p, fmt.Sprintf("calendar.periods: %s", err.Error()))
+		}
+	}
+	if _, ok := c.Profiles[c.Calendar.DefaultProfile]; c.Calendar.File != "" && !ok {
+		p = append(p, fmt.Sprintf("calendar.defaultProfile: unknown profile %q", c.Calendar.DefaultProfile))
+	}
+
+	if c.API.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.API.Listen); err != nil {
+			p = append(p, fmt.Sprintf("api.listen: invalid address %q (e.g. :8080)", c.API.Listen))
+		}
+	}
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "error":
+	default:
+		p = append(p, fmt.Sprintf("log.level: must be debug, info, warn or error (%q)", c.Log.Level))
+	}
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		p = append(p, fmt.Sprintf("log.format: must be text or json (%q)", c.Log.Format))
+	}
+
+	if c.Relays.ReconcileInterval < 0 {
+		p = append(p, fmt.Sprintf("relays.reconcileInterval: must not be negative (%s)", c.Relays.ReconcileInterval))
+	}
+	if c.Relays.RespectManual && c.Relays.ReconcileInterval <= 0 {
+		p = append(p, "relays.respectManual: requires relays.reconcileInterval (RECONCILE_INTERVAL)")
+	}
+	if c.Relays.ManualTimeout < 0 {
+		p = append(p, fmt.Sprintf("relays.manualTimeout: must not be negative (%s)", c.Relays.ManualTimeout))
+	}
+
+	switch strings.ToLower(c.Shutdown.Mode) {
+	case "normal", "lowered", ShutdownKeep:
+	default:
+		p = append(p, fmt.Sprintf("shutdown.mode: must be normal, lowered or keep (%q)", c.Shutdown.Mode))
+	}
+
+	if c.Audit.RetentionDays < 0 {
+		p = append(p, fmt.Sprintf("audit.retentionDays: must not be negative (%d)", c.Audit.RetentionDays))
+	}
+
+	if c.Building != (Building{}) && (c.Building.HeatLoss <= 0 || c.Building.Capacity <= 0 || c.Building.HeatingPower <= 0) {
+		p = append(p, "building: heatLoss, capacity and heatingPower must all be positive (BUILDING_HEAT_LOSS, BUILDING_CAPACITY, BUILDING_HEATING_POWER)")
+	}
+	return p
+}
+
+func validateStrategy(field string, st Strategy) (p Problems) {
+	if st.Threshold < 0 {
+		p = append(p, fmt.Sprintf("%s.threshold: must not be negative (%v)", field, st.Threshold))
+	}
+	if st.ActiveHours < 0 || st.ActiveHours > 24 {
+		p = append(p, fmt.Sprintf("%s.activeHours: must be between 0 and 24 (%d)", field, st.ActiveHours))
+	}
+	total := 0
+	for _, hours := range st.BlockHours {
+		if hours < 1 || hours > 24 {
+			p = append(p, fmt.Sprintf("%s.blockHours: block length must be between 1 and 24 (%d)", field, hours))
+		}
+		total += hours
+	}
+	if total > 24 {
+		p = append(p, fmt.Sprintf("%s.blockHours: blocks do not fit into a day (%d hours)", field, total))
+	}
+	if st.Relative.Percentile != nil && st.Relative.Median != nil {
+		p = append(p, field+".relative: percentile and median are mutually exclusive")
+	}
+	if st.Relative.Percentile != nil && (*st.Relative.Percentile < 0 || *st.Relative.Percentile > 100) {
+		p = append(p, fmt.Sprintf("%s.relative.percentile: must be between 0 and 100 (%v)", field, *st.Relative.Percentile))
+	}
+	if st.Relative.Window != WindowDay && st.Relative.Window != WindowRolling {
+		p = append(p, fmt.Sprintf("%s.relative.window: must be day or rolling (%q)", field, st.Relative.Window))
+	}
+	if st.Preheat.Hours < 1 {
+		p = append(p, fmt.Sprintf("%s.preheat.hours: must be at least 1 (%d)", field, st.Preheat.Hours))
+	}
+	return p
+}
+
+func validateSchedule(field string, windows []string) (p Problems) {
+	for _, w := range windows {
+		if _, err := schedule.ParseWindow(w); err != nil {
+			p = append(p, fmt.Sprintf("%s: %s", field, err.Error()))
+		}
+	}
+	return p
+}
+
+// profileNames returns sorted profile names
+func (c Config) profileNames() (names []string) {
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setDefaults sets the defaults of the main strategy to a profile strategy
+func (st *Strategy) setDefaults() {
+	def := Default().Strategy
+	if st.Relative.Window == "" {
+		st.Relative.Window = def.Relative.Window
+	}
+	if st.Preheat == (Preheat{}) {
+		st.Preheat = def.Preheat
+	}
+}
+
+func validateURL(field, str string) (p Problems) {
+	if str == "" {
+		return nil
+	}
+	u, err := url.Parse(str)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		p = append(p, fmt.Sprintf("%s: invalid URL %q", field, str))
+	}
+	return p
+}
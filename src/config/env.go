@@ -0,0 +1,174 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/schedule"
+)
+
+// applyEnv overrides configuration with environment variables. Problems parsing the variables are returned.
+func applyEnv(c *Config) (p Problems) {
+	str := func(name string, v *string) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			*v = value
+		}
+	}
+	float := func(name string, v *float64) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			p = append(p, fmt.Sprintf("%s: failed to parse float: %q", name, value))
+			return
+		}
+		*v = f
+	}
+	optionalFloat := func(name string, v **float64) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			p = append(p, fmt.Sprintf("%s: failed to parse float: %q", name, value))
+			return
+		}
+		*v = &f
+	}
+	integer := func(name string, v *int) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			p = append(p, fmt.Sprintf("%s: failed to parse int: %q", name, value))
+			return
+		}
+		*v = i
+	}
+	integers := func(name string, v *[]int) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		var list []int
+		for _, s := range strings.Split(value, ",") {
+			i, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				p = append(p, fmt.Sprintf("%s: failed to parse array of integers: %q", name, value))
+				return
+			}
+			list = append(list, i)
+		}
+		*v = list
+	}
+	windows := func(name string, v *[]string) {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			*v = schedule.Split(value)
+		}
+	}
+	duration := func(name string, v *time.Duration) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			p = append(p, fmt.Sprintf("%s: failed to parse duration: %q", name, value))
+			return
+		}
+		*v = d
+	}
+	boolean := func(name string, v *bool) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			p = append(p, fmt.Sprintf("%s: failed to parse bool: %q", name, value))
+			return
+		}
+		*v = b
+	}
+
+	str("TZ", &c.Timezone)
+
+	str("PRICE_PROVIDER", &c.Provider.Name)
+	str("TOKEN", &c.Provider.Token)
+	str("PRICE_ZONE", &c.Provider.Zone)
+
+	float("PRICE_VAT", &c.Pricing.VAT)
+	float("PRICE_MARGIN", &c.Pricing.Margin)
+	float("PRICE_TRANSFER", &c.Pricing.Transfer)
+
+	float("THRESHOLD", &c.Strategy.Threshold)
+	float("MAX_PRICE", &c.Strategy.MaxPrice)
+	integer("ACTIVE_HOURS", &c.Strategy.ActiveHours)
+	integers("BLOCK_HOURS", &c.Strategy.BlockHours)
+	optionalFloat("RELATIVE_PERCENTILE", &c.Strategy.Relative.Percentile)
+	optionalFloat("RELATIVE_MEDIAN", &c.Strategy.Relative.Median)
+	str("RELATIVE_WINDOW", &c.Strategy.Relative.Window)
+	float("RELATIVE_FLOOR", &c.Strategy.Relative.Floor)
+	optionalFloat("ALWAYS_ON_PRICE", &c.Strategy.AlwaysOn.Price)
+	boolean("ALWAYS_ON_BOOST", &c.Strategy.AlwaysOn.Boost)
+	integer("PREHEAT_HOURS", &c.Strategy.Preheat.Hours)
+	float("PREHEAT_DROP", &c.Strategy.Preheat.Drop)
+	float("PREHEAT_SPREAD", &c.Strategy.Preheat.Spread)
+
+	windows("SCHEDULE", &c.Schedule)
+	windows("FORCED_ON", &c.Forced.On)
+	windows("FORCED_OFF", &c.Forced.Off)
+
+	str("CALENDAR_FILE", &c.Calendar.File)
+	str("OVERRIDE_FILE", &c.Override.File)
+	str("API_LISTEN", &c.API.Listen)
+	str("API_TOKEN", &c.API.Token)
+
+	str("AUDIT_FILE", &c.Audit.File)
+	integer("AUDIT_RETENTION_DAYS", &c.Audit.RetentionDays)
+
+	str("PRICE_FILE", &c.Prices.File)
+
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+
+	str("SHUTDOWN_MODE", &c.Shutdown.Mode)
+
+	str("SHELLY_URL", &c.Relays.URL)
+	str("BOOST_SHELLY_URL", &c.Relays.BoostURL)
+	str("EVU_SHELLY_URL", &c.Relays.EVUURL)
+	duration("RECONCILE_INTERVAL", &c.Relays.ReconcileInterval)
+	boolean("RESPECT_MANUAL", &c.Relays.RespectManual)
+	duration("MANUAL_TIMEOUT", &c.Relays.ManualTimeout)
+
+	str("OUTDOOR_SOURCE", &c.Outdoor.Source)
+	str("OUTDOOR_URL", &c.Outdoor.URL)
+	str("OUTDOOR_PLACE", &c.Outdoor.Place)
+	integer("OUTDOOR_FORECAST_HOURS", &c.Outdoor.ForecastHours)
+	str("HEATING_CURVE", &c.Outdoor.HeatingCurve)
+
+	str("INDOOR_SOURCE", &c.Indoor.Source)
+	str("INDOOR_URL", &c.Indoor.URL)
+	str("INDOOR_MQTT_BROKER", &c.Indoor.MQTTBroker)
+	str("INDOOR_MQTT_TOPIC", &c.Indoor.MQTTTopic)
+	duration("INDOOR_MAX_AGE", &c.Indoor.MaxAge)
+	optionalFloat("INDOOR_MIN", &c.Indoor.Min)
+	optionalFloat("INDOOR_MAX", &c.Indoor.Max)
+
+	optionalFloat("FROST_OUTDOOR_LIMIT", &c.Frost.OutdoorLimit)
+	float("FROST_INDOOR_LIMIT", &c.Frost.IndoorLimit)
+
+	float("BUILDING_HEAT_LOSS", &c.Building.HeatLoss)
+	float("BUILDING_CAPACITY", &c.Building.Capacity)
+	float("BUILDING_HEATING_POWER", &c.Building.HeatingPower)
+
+	return p
+}
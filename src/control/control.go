@@ -1,9 +1,87 @@
 package control
 
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
 type Control interface {
-	Init(dryRun bool) error
-	SwitchOn() error
-	SwitchOff() error
+	InitWithConfig(c Config, dryRun bool) error
+	SwitchOn(ctx context.Context) error
+	SwitchOff(ctx context.Context) error
+	EVUStop(ctx context.Context) error
+	Set(ctx context.Context, mode Mode) error
+	SetBoost(ctx context.Context, on bool) error
+}
+
+// Mode is the operating mode of the heat pump
+type Mode int
+
+const (
+	// Normal is the normal operating mode (relay is off)
+	Normal Mode = iota
+	// Lowered is the room lowering mode (relay is on)
+	Lowered
+	// EVUStop stops heating (EVU relay is on)
+	EVUStop
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Normal:
+		return "NORMAL"
+	case Lowered:
+		return "LOWERED"
+	case EVUStop:
+		return "EVU STOP"
+	}
+	return "UNKNOWN"
+}
+
+// ParseMode parses operating mode (normal, lowered or evustop, case insensitive)
+func ParseMode(str string) (Mode, error) {
+	switch strings.ToLower(strings.Join(strings.Fields(str), "")) {
+	case "normal":
+		return Normal, nil
+	case "lowered":
+		return Lowered, nil
+	case "evustop":
+		return EVUStop, nil
+	}
+	return Normal, fmt.Errorf("unknown mode %q (normal, lowered or evustop)", str)
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (m Mode) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (m *Mode) UnmarshalText(text []byte) (err error) {
+	*m, err = ParseMode(string(text))
+	return err
 }
 
 type HourPrices map[string][]float64
+
+// Status is the operating mode and the state of the relays
+type Status struct {
+	Mode   Mode          `json:"mode"`
+	Boost  bool          `json:"boost"`
+	Relays []RelayStatus `json:"relays"`
+}
+
+// RelayStatus is the state of a relay
+type RelayStatus struct {
+	Name   string `json:"name"`
+	On     bool   `json:"on"`
+	Source string `json:"source"` // source of the last change reported by the relay (e.g. http, input or app)
+}
+
+// Config is the relay configuration
+type Config struct {
+	URL      string // relay for ROOM LOWERING mode
+	BoostURL string // optional boost relay
+	EVUURL   string // optional EVU STOP relay
+}
@@ -1,50 +1,151 @@
 package control
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestInit(t *testing.T) {
+func TestInitWithConfig(t *testing.T) {
 	s := State{}
 
-	if err := s.Init(false); err != nil {
-		t.Errorf("init() with defaults did not succeed")
+	if err := s.InitWithConfig(Config{}, false); err == nil {
+		t.Errorf("InitWithConfig() without relay URL should have failed, but it succeeded")
 	}
 
-	if err := s.Init(true); err != nil {
-		t.Errorf("init() with defaults did not succeed")
+	if err := s.InitWithConfig(Config{URL: "http://127.0.0.1"}, true); err != nil {
+		t.Errorf("InitWithConfig() did not succeed: %s", err.Error())
+	}
+}
+
+// newTestRelay returns a stand-in for Shelly relay API
+func newTestRelay(ison *bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("turn") {
+		case "on":
+			*ison = true
+		case "off":
+			*ison = false
+		}
+		json.NewEncoder(w).Encode(statusResponse{Ison: *ison, Source: "http"})
+	}))
+}
+
+func TestSet(t *testing.T) {
+	var relay, evu bool
+	relayServer := newTestRelay(&relay)
+	defer relayServer.Close()
+	evuServer := newTestRelay(&evu)
+	defer evuServer.Close()
+
+	// cases are run in order, relay state is carried over from the previous case
+	cases := []struct {
+		name          string
+		evuUrl        string
+		dryRun        bool
+		mode          Mode
+		expectedRelay bool
+		expectedEVU   bool
+		expectedError bool
+	}{
+		{name: "Lowered", mode: Lowered, expectedRelay: true},
+		{name: "Normal", mode: Normal, expectedRelay: false},
+		{name: "EVU STOP without relay", mode: EVUStop, expectedError: true},
+		{name: "EVU STOP", evuUrl: evuServer.URL, mode: EVUStop, expectedEVU: true},
+		{name: "Lowered after EVU STOP", evuUrl: evuServer.URL, mode: Lowered, expectedRelay: true},
+		{name: "Dry run", evuUrl: evuServer.URL, dryRun: true, mode: Normal, expectedRelay: true},
+		{name: "Normal after dry run", evuUrl: evuServer.URL, mode: Normal},
 	}
 
-	os.Setenv("SHELLY_URL", "http://127.0.0.1")
-	if err := s.Init(false); err != nil {
-		t.Errorf("init() did not succeed")
+	for _, tc := range cases {
+		k := tc.name
+		s := State{url: relayServer.URL, evuUrl: tc.evuUrl, dryRun: tc.dryRun}
+		err := s.Set(context.Background(), tc.mode)
+		if (err != nil) != tc.expectedError {
+			t.Fatalf("%s: Set\ngot:  %v\nwant error: %v\n", k, err, tc.expectedError)
+		}
+		if relay != tc.expectedRelay || evu != tc.expectedEVU {
+			t.Fatalf("%s: Set\ngot:  relay: %v, evu: %v\nwant: relay: %v, evu: %v\n", k, relay, evu, tc.expectedRelay, tc.expectedEVU)
+		}
 	}
-	os.Unsetenv("SHELLY_URL")
 }
 
-// Need to mock http client..
-//func TestSwitchOn(t *testing.T) {
-//	cases := map[string]struct {
-//		expectedResult error
-//	}{
-//		"SwitchON when Switch is OFF": {
-//			expectedResult: nil,
-//		},
-//	}
-//
-//	os.Setenv("SHELLY_URL", "http://")
-//	s := State{}
-//	s.Init()
-//
-//	for k, tc := range cases {
-//		err := s.SwitchOn()
-//		if err != tc.expectedResult {
-//			t.Fatalf("%s: SwtichOn\ngot:  %s\nwant: %s\n", k, err, tc.expectedResult)
-//		}
-//	}
-//}
+func TestStatus(t *testing.T) {
+	var relay, boost, evu bool
+	relayServer := newTestRelay(&relay)
+	defer relayServer.Close()
+	boostServer := newTestRelay(&boost)
+	defer boostServer.Close()
+	evuServer := newTestRelay(&evu)
+	defer evuServer.Close()
+
+	cases := map[string]struct {
+		relay, boost, evu bool
+		expectedMode      Mode
+	}{
+		"Normal":           {expectedMode: Normal},
+		"Normal and boost": {boost: true, expectedMode: Normal},
+		"Lowered":          {relay: true, expectedMode: Lowered},
+		"EVU STOP":         {evu: true, expectedMode: EVUStop},
+		"Both relays on":   {relay: true, evu: true, expectedMode: EVUStop},
+	}
+
+	s := State{url: relayServer.URL, boostUrl: boostServer.URL, evuUrl: evuServer.URL}
+	for k, tc := range cases {
+		relay, boost, evu = tc.relay, tc.boost, tc.evu
+		status, err := s.Status(context.Background())
+		if err != nil {
+			t.Fatalf("%s: Status did not succeed: %s", k, err.Error())
+		}
+		if status.Mode != tc.expectedMode || status.Boost != tc.boost || len(status.Relays) != 3 || status.Relays[0].Source != "http" {
+			t.Fatalf("%s: Status\ngot:  %+v\nwant: %s (boost: %v)\n", k, status, tc.expectedMode, tc.boost)
+		}
+	}
+
+	relayServer.Close()
+	if _, err := s.Status(context.Background()); err == nil {
+		t.Fatalf("Status should have failed when relay is not reachable, but it succeeded")
+	}
+}
+
+func TestHangingRelay(t *testing.T) {
+	hang := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-hang:
+		}
+	}))
+	defer server.Close()
+	defer close(hang)
+
+	cases := map[string]struct {
+		timeout time.Duration
+		cancel  bool
+	}{
+		"Cancelled": {timeout: requestTimeout, cancel: true},
+		"Timeout":   {timeout: 50 * time.Millisecond},
+	}
+
+	for k, tc := range cases {
+		s := State{url: server.URL, hc: &http.Client{Timeout: tc.timeout}}
+		ctx, cancel := context.WithCancel(context.Background())
+		if tc.cancel {
+			time.AfterFunc(50*time.Millisecond, cancel)
+		}
+		start := time.Now()
+		err := s.Set(ctx, Lowered)
+		cancel()
+		if err == nil || time.Since(start) > time.Second {
+			t.Fatalf("%s: Set should have failed quickly, got: %v after %s", k, err, time.Since(start))
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package control
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	relaySwitches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "thermia_relay_switches_total",
+		Help: "Number of relay switches made by the controller",
+	}, []string{"relay", "state"})
+	shellyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "thermia_shelly_request_duration_seconds",
+		Help:    "Duration of Shelly relay requests",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation"})
+	shellyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "thermia_shelly_request_failures_total",
+		Help: "Number of failed Shelly relay requests",
+	}, []string{"operation"})
+)
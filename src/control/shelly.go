@@ -1,22 +1,25 @@
 package control
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os"
-)
+	"time"
 
-const (
-	defaultShellyUrl = "http://10.0.0.84/relay/0"
+	"github.com/koovee/thermia/logging"
 )
 
+const requestTimeout = 10 * time.Second // per relay request, so that a hanging Shelly does not block the controller
+
 type State struct {
-	url    string
-	hc     *http.Client
-	dryRun bool
+	url      string
+	boostUrl string
+	evuUrl   string
+	hc       *http.Client
+	dryRun   bool
 }
 
 type statusResponse struct {
@@ -30,96 +33,196 @@ type statusResponse struct {
 
 var s State
 
-func (s *State) Init(dryRun bool) error {
-	err := s.getEnv()
-	if err != nil {
-		fmt.Printf("failed to get required environment variables")
-		return err
+var log = logging.Component("control")
+
+// InitWithConfig initializes the module with a given configuration. Relay URL is required.
+func (s *State) InitWithConfig(c Config, dryRun bool) error {
+	if c.URL == "" {
+		return errors.New("relay URL not set")
 	}
+	s.url = c.URL
+	s.boostUrl = c.BoostURL
+	s.evuUrl = c.EVUURL
 	s.dryRun = dryRun
+	s.hc = &http.Client{Timeout: requestTimeout}
 	return nil
 }
 
 // SwitchOff turns switch OFF which means Thermia is operating in NORMAL mode
-func (s State) SwitchOff() error {
-	var response statusResponse
+func (s State) SwitchOff(ctx context.Context) error {
+	err := s.setRelay(ctx, s.url, false, "NORMAL OPERATION")
+	if err != nil {
+		return err
+	}
+	if s.evuUrl != "" {
+		return s.setRelay(ctx, s.evuUrl, false, "EVU STOP OFF")
+	}
+	return nil
+}
 
-	// check current state
-	resp, err := http.Get(s.url)
+// SwitchOn tunrs switch ON which means Thermia is operating in heat reduction mode (normal-2 degress)
+func (s State) SwitchOn(ctx context.Context) error {
+	if s.evuUrl != "" {
+		err := s.setRelay(ctx, s.evuUrl, false, "EVU STOP OFF")
+		if err != nil {
+			return err
+		}
+	}
+	return s.setRelay(ctx, s.url, true, "EVU ON / LOWERED TEMPERATURE")
+}
+
+// EVUStop turns EVU relay ON which means Thermia heating is stopped. EVU relay is optional (EVU_SHELLY_URL).
+func (s State) EVUStop(ctx context.Context) error {
+	if s.evuUrl == "" {
+		return errors.New("EVU relay not configured")
+	}
+	err := s.setRelay(ctx, s.url, false, "LOWERED TEMPERATURE OFF")
 	if err != nil {
-		fmt.Printf("Failed to create http request")
-		return errors.New("failed to create http request")
+		return err
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)                  // response body is []byte
-	if err := json.Unmarshal(body, &response); err != nil { // Parse []byte to go struct pointer
-		fmt.Printf("Can not unmarshal JSON: %s\n", err.Error())
-		return errors.New("failed to unmarshal JSON response")
-	}
-	if response.Ison == true {
-		// change state
-		if s.dryRun {
-			fmt.Printf("DRY RUN -- Switch is on, turning it off (NORMAL OPERATION) -- DRY RUN\n")
-		} else {
-			fmt.Printf("Switch is on, turning it off (NORMAL OPERATION)\n")
-			resp, err := http.Get(s.url + "?turn=off")
-			if err != nil {
-				fmt.Printf("Failed to create http request")
-				return errors.New("failed to create http request")
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				fmt.Printf("failed to set switch off: %s\n", err.Error())
-				return errors.New("failed to set switch off")
-			}
+	return s.setRelay(ctx, s.evuUrl, true, "EVU STOP")
+}
+
+// Set sets the heat pump to a given operating mode
+func (s State) Set(ctx context.Context, mode Mode) error {
+	switch mode {
+	case Normal:
+		return s.SwitchOff(ctx)
+	case Lowered:
+		return s.SwitchOn(ctx)
+	case EVUStop:
+		return s.EVUStop(ctx)
+	}
+	return fmt.Errorf("unknown mode: %d", mode)
+}
+
+// SetBoost turns the boost relay on or off. Boost relay is optional (BOOST_SHELLY_URL) and this is no-op when it is not set.
+func (s State) SetBoost(ctx context.Context, on bool) error {
+	if s.boostUrl == "" {
+		return nil
+	}
+	if on {
+		return s.setRelay(ctx, s.boostUrl, true, "BOOST")
+	}
+	return s.setRelay(ctx, s.boostUrl, false, "BOOST OFF")
+}
+
+// Status returns the operating mode and the state of the relays
+func (s State) Status(ctx context.Context) (status Status, err error) {
+	relays := []struct {
+		name, url string
+	}{{"relay", s.url}, {"boost", s.boostUrl}, {"evu", s.evuUrl}}
+
+	for _, r := range relays {
+		if r.url == "" {
+			continue
+		}
+		response, err := s.getStatus(ctx, r.url)
+		if err != nil {
+			return status, fmt.Errorf("failed to get %s status: %w", r.name, err)
+		}
+		status.Relays = append(status.Relays, RelayStatus{Name: r.name, On: response.Ison, Source: response.Source})
+		switch {
+		case r.name == "evu" && response.Ison:
+			status.Mode = EVUStop
+		case r.name == "relay" && response.Ison && status.Mode != EVUStop:
+			status.Mode = Lowered
+		case r.name == "boost":
+			status.Boost = response.Ison
 		}
 	}
+	return status, nil
+}
 
-	return nil
+// client returns the HTTP client (State may be used without Init, e.g. in tests)
+func (s State) client() *http.Client {
+	if s.hc == nil {
+		return &http.Client{Timeout: requestTimeout}
+	}
+	return s.hc
 }
 
-// SwitchOn tunrs switch ON which means Thermia is operating in heat reduction mode (normal-2 degress)
-func (s State) SwitchOn() error {
-	var response statusResponse
+// get sends GET request to the relay. Request is cancelled with ctx and times out after requestTimeout.
+func (s State) get(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return s.client().Do(req)
+}
 
-	// check current state
-	resp, err := http.Get(s.url)
+// getStatus returns the current state of the relay
+func (s State) getStatus(ctx context.Context, url string) (response statusResponse, err error) {
+	start := time.Now()
+	resp, err := s.get(ctx, url)
+	shellyDuration.WithLabelValues("status").Observe(time.Since(start).Seconds())
 	if err != nil {
-		fmt.Printf("Failed to create http request")
-		return errors.New("failed to create http request")
+		shellyFailures.WithLabelValues("status").Inc()
+		log.Error("failed to get relay status", "url", url, "error", err)
+		return response, errors.New("failed to create http request")
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)                  // response body is []byte
 	if err := json.Unmarshal(body, &response); err != nil { // Parse []byte to go struct pointer
-		fmt.Printf("Can not unmarshal JSON: %s\n", err.Error())
-		return errors.New("failed to unmarshal JSON response")
-	}
-	if response.Ison == false {
-		// change state
-		if s.dryRun {
-			fmt.Printf("DRY RUN -- Switch is off, turning it on (EVU ON / LOWERED TEMPERATURE) -- DRY RUN\n")
-		} else {
-			fmt.Printf("Switch is off, turning it on (EVU ON / LOWERED TEMPERATURE)\n")
-			resp, err := http.Get(s.url + "?turn=on")
-			if err != nil {
-				fmt.Printf("Failed to create http request")
-				return errors.New("failed to create http request")
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				fmt.Printf("failed to set switch on: %s\n", err.Error())
-				return errors.New("failed to set switch on")
-			}
-		}
+		log.Error("failed to unmarshal relay status", "url", url, "error", err)
+		return response, errors.New("failed to unmarshal JSON response")
 	}
+	return response, nil
+}
 
+// setRelay turns relay on or off if it is not already in that state
+func (s State) setRelay(ctx context.Context, url string, on bool, description string) error {
+	// check current state
+	response, err := s.getStatus(ctx, url)
+	if err != nil {
+		return err
+	}
+	if response.Ison == on {
+		return nil
+	}
+
+	turn := "off"
+	if on {
+		turn = "on"
+	}
+	// change state
+	if s.dryRun {
+		log.Info("dry run, not switching relay", "relay", s.relayName(url), "state", state(response.Ison), "turn", turn, "description", description)
+		return nil
+	}
+	log.Info("switching relay", "relay", s.relayName(url), "state", state(response.Ison), "turn", turn, "description", description)
+	start := time.Now()
+	resp, err := s.get(ctx, url+"?turn="+turn)
+	shellyDuration.WithLabelValues("switch").Observe(time.Since(start).Seconds())
+	if err != nil {
+		shellyFailures.WithLabelValues("switch").Inc()
+		log.Error("failed to switch relay", "relay", s.relayName(url), "turn", turn, "error", err)
+		return errors.New("failed to create http request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		shellyFailures.WithLabelValues("switch").Inc()
+		log.Error("failed to switch relay", "relay", s.relayName(url), "turn", turn, "status", resp.Status)
+		return errors.New("failed to set switch " + turn)
+	}
+	relaySwitches.WithLabelValues(s.relayName(url), turn).Inc()
 	return nil
 }
 
-func (s *State) getEnv() error {
-	s.url = os.Getenv("SHELLY_URL")
-	if s.url == "" {
-		s.url = defaultShellyUrl
+// relayName returns the name of the relay (relay, boost or evu) used in metrics and status
+func (s State) relayName(url string) string {
+	switch url {
+	case s.boostUrl:
+		return "boost"
+	case s.evuUrl:
+		return "evu"
 	}
-	return nil
+	return "relay"
+}
+
+func state(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
 }
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+)
+
+// frost protection is a safety layer above all strategies and overrides
+type frost struct {
+	outdoorLimit    float64 // NORMAL mode is forced when outdoor temperature is lower than outdoorLimit
+	hasOutdoorLimit bool
+	indoorLimit     float64 // NORMAL mode is forced when indoor temperature is lower than indoorLimit
+}
+
+// newFrost returns frost protection limits from configuration
+func newFrost(c config.Frost) (f frost) {
+	if c.OutdoorLimit != nil {
+		f.hasOutdoorLimit, f.outdoorLimit = true, *c.OutdoorLimit
+	}
+	f.indoorLimit = c.IndoorLimit
+	return
+}
+
+// applyFrostProtection forces NORMAL mode when outdoor temperature is lower than the outdoor limit or indoor
+// temperature (if known) is lower than the indoor limit. EVU STOP is never allowed while frost protection is active.
+func (s state) applyFrostProtection(d decision) decision {
+	var reason string
+	if s.frost.hasOutdoorLimit && s.outdoor.known && s.outdoor.current < s.frost.outdoorLimit {
+		reason = fmt.Sprintf("FROST PROTECTION: outdoor temperature lower than limit: %0.1f (limit: %0.1f)", s.outdoor.current, s.frost.outdoorLimit)
+	} else if s.indoor.known && s.indoor.temperature < s.frost.indoorLimit {
+		reason = fmt.Sprintf("FROST PROTECTION: indoor temperature lower than limit: %0.1f (limit: %0.1f)", s.indoor.temperature, s.frost.indoorLimit)
+	} else {
+		return d
+	}
+
+	if d.mode != control.Normal {
+		reason = fmt.Sprintf("%s, overriding %s mode (%s)", reason, d.mode, d.reason)
+	}
+	s.logger().Warn("frost protection", "reason", reason)
+	return decision{
+		mode:            control.Normal,
+		boost:           d.boost,
+		reason:          reason,
+		frostProtection: true,
+	}
+}
+
+// applyFrostProtectionNow reads the configured temperature sources once and applies frost protection to the decision.
+// Used when the relays are set without the control loop (e.g. thermia set).
+func applyFrostProtectionNow(ctx context.Context, cfg config.Config, d decision) (decision, error) {
+	s := state{frost: newFrost(cfg.Frost)}
+	var err error
+	if s.outdoor, err = newOutdoor(cfg.Outdoor, clock.Real); err != nil {
+		return d, err
+	}
+	defer closeSources(s.outdoor.source)
+	if s.indoor, err = newIndoor(cfg.Indoor, clock.Real); err != nil {
+		return d, err
+	}
+	defer closeSources(s.indoor.source)
+
+	s.updateOutdoorTemperature(ctx)
+	s.updateIndoorTemperature(ctx)
+	return s.applyFrostProtection(d), nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/temperature"
+)
+
+// indoor keeps indoor temperature within comfort limits regardless of price
+type indoor struct {
+	source      temperature.Source
+	min         float64 // heating is forced ON when temperature is lower than min
+	max         float64 // heating may be lowered when temperature is higher than max
+	hasMin      bool
+	hasMax      bool
+	temperature float64 // last known indoor temperature
+	known       bool
+}
+
+// newIndoor returns indoor temperature source and comfort limits from a validated configuration
+func newIndoor(c config.Indoor, clk clock.Clock) (i indoor, err error) {
+	switch c.Source {
+	case config.SourceHTTP:
+		i.source = temperature.NewHTTPSource(c.URL, c.MaxAge, clk)
+	case config.SourceShelly:
+		i.source = temperature.NewShellyHTSource(c.URL, c.MaxAge, clk)
+	case config.SourcePush:
+		i.source = temperature.NewPushSource(c.MaxAge, clk)
+	case config.SourceMQTT:
+		i.source, err = temperature.NewMQTTSource(c.MQTTBroker, c.MQTTTopic, c.MaxAge, clk)
+		if err != nil {
+			log.Error("failed to connect to MQTT broker", "broker", c.MQTTBroker, "error", err)
+			return
+		}
+	}
+
+	if c.Min != nil {
+		i.hasMin, i.min = true, *c.Min
+	}
+	if c.Max != nil {
+		i.hasMax, i.max = true, *c.Max
+	}
+	return
+}
+
+// updateIndoorTemperature reads indoor temperature. Temperature is unknown (limits are not applied) when reading fails.
+func (s *state) updateIndoorTemperature(ctx context.Context) {
+	if s.indoor.source == nil {
+		return
+	}
+
+	t, err := s.indoor.source.Current(ctx)
+	if err != nil {
+		log.Warn("failed to get indoor temperature", "error", err)
+		s.indoor.known = false
+		return
+	}
+	s.indoor.temperature = t
+	s.indoor.known = true
+	log.Info("indoor temperature", "temperature", t)
+}
+
+// indoorZone tells which indoor limits a temperature is beyond
+type indoorZone struct {
+	frost, belowMin, aboveMax bool
+}
+
+// zone returns the indoor limits the temperature is beyond. Temperature that is not known is not beyond any limit.
+func (s state) zone(t float64, known bool) indoorZone {
+	if !known {
+		return indoorZone{}
+	}
+	return indoorZone{
+		frost:    t < s.frost.indoorLimit,
+		belowMin: s.indoor.hasMin && t < s.indoor.min,
+		aboveMax: s.indoor.hasMax && t > s.indoor.max,
+	}
+}
+
+// watchIndoor sends to changed when a temperature received in the background (pushed or MQTT) crosses the indoor
+// minimum, maximum or frost protection limit, so that the limits apply without waiting for the next control cycle.
+// Called again after reload, as the source or the limits may change.
+func (s state) watchIndoor(changed chan<- struct{}) {
+	n, ok := s.indoor.source.(temperature.Notifier)
+	if !ok {
+		return
+	}
+	var m sync.Mutex
+	previous := s.zone(s.indoor.temperature, s.indoor.known)
+	n.Notify(func(t float64) {
+		m.Lock()
+		defer m.Unlock()
+		zone := s.zone(t, true)
+		if zone != previous {
+			log.Info("indoor temperature crossed a limit", "temperature", t)
+			notify(changed)
+		}
+		previous = zone
+	})
+}
+
+// applyIndoorMaximum allows lowering even in cheap hours when indoor temperature is higher than maximum
+func (s state) applyIndoorMaximum(d decision) decision {
+	if !s.indoor.known || !s.indoor.hasMax || s.indoor.temperature <= s.indoor.max || d.mode != control.Normal {
+		return d
+	}
+	return decision{
+		mode:   control.Lowered,
+		reason: fmt.Sprintf("indoor temperature higher than maximum: %0.1f (maximum: %0.1f)", s.indoor.temperature, s.indoor.max),
+	}
+}
+
+// applyIndoorMinimum forces NORMAL mode when indoor temperature is lower than minimum
+func (s state) applyIndoorMinimum(d decision) decision {
+	if !s.indoor.known || !s.indoor.hasMin || s.indoor.temperature >= s.indoor.min || d.mode == control.Normal {
+		return d
+	}
+	return decision{
+		mode:   control.Normal,
+		boost:  d.boost,
+		reason: fmt.Sprintf("indoor temperature lower than minimum: %0.1f (minimum: %0.1f)", s.indoor.temperature, s.indoor.min),
+	}
+}
@@ -1,307 +1,397 @@
 package main
 
 import (
-	"errors"
-	"flag"
+	"context"
 	"fmt"
+	"net/http"
 	"os"
-	"strconv"
-	"strings"
+	"os/signal"
+	"reflect"
+	"syscall"
 	"time"
 
+	"github.com/koovee/thermia/audit"
+	"github.com/koovee/thermia/calendar"
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
 	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/logging"
+	"github.com/koovee/thermia/override"
+	"github.com/koovee/thermia/pricestore"
+	"github.com/koovee/thermia/schedule"
 	"github.com/koovee/thermia/spotprice"
+	"github.com/koovee/thermia/temperature"
 )
 
 const (
-	defaultTimezone = "Europe/Helsinki"
-	defaultSchedule = "0,1,2,3,4,5"
+	relativePercentile = "percentile"
+	relativeMedian     = "median"
+
+	shutdownTimeout = 5 * time.Second // for HTTP API requests to finish
 )
 
 var version string
 
+var log = logging.Component("controller")
+
 type state struct {
 	sp          spotprice.State
 	cs          control.State
 	threshold   float64
 	maxPrice    float64
 	activeHours int
-	schedule    map[int]bool
-	tz          string
+	blockHours  []int
+	relative    relativeThreshold
+	alwaysOn    alwaysOnPrice
+	outdoor     outdoor
+	indoor      indoor
+	frost       frost
+	preheat     preheat
+	schedule    schedule.Schedule
+	forcedOn    schedule.Schedule
+	forcedOff   schedule.Schedule
+	overrides   *override.Store
+	audit       *audit.Log
+	prices      *pricestore.Store
+	profiles    map[string]profile
+	calendar    calendar.Calendar
+	cfg         config.Config
+	dryRun      bool
+	quiet       bool      // do not log decisions
+	applied     *decision // decision of the last control cycle (relays are reconciled to it)
+	clock       clock.Clock
 }
 
-func main() {
+// alwaysOnPrice defines the price under which heating is always ON regardless of the strategy
+type alwaysOnPrice struct {
+	enabled bool
+	price   float64 // c/kWh
+	boost   bool    // turn boost relay on when price is lower than price
+}
 
-	dryRun := flag.Bool("dryrun", false, "disable relay control")
-	flag.Parse()
+// relativeThreshold defines a threshold relative to the price distribution of the day (or the next 24 hours)
+type relativeThreshold struct {
+	mode   string  // relativePercentile or relativeMedian
+	value  float64 // percentile (0-100) or percentage above median
+	window string  // config.WindowDay or config.WindowRolling
+	floor  float64 // heating is always ON when price is lower than floor (c/kWh)
+}
 
-	s, err := getEnv()
+func main() {
+	// SIGINT and SIGTERM cancel in-flight requests and stop the command (e.g. the controller) cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := execute(ctx, os.Args[1:])
+	stop()
 	if err != nil {
-		fmt.Printf("failed to get required environment variables\n")
-		return
+		fmt.Fprintf(os.Stderr, "thermia: %s\n", err.Error())
+		os.Exit(1)
 	}
+}
 
-	_, err = time.LoadLocation(s.tz)
+// runController runs the controller until ctx is cancelled. Relays are left in the configured safe state and the
+// prices are saved before returning.
+func runController(ctx context.Context, configFile string, cfg config.Config, dryRun bool) error {
+	s, err := newState(cfg, dryRun, clock.Real, nil)
 	if err != nil {
-		fmt.Printf("failed to set timezone (%s): %s\n", s.tz, err.Error())
-		return
+		return fmt.Errorf("failed to initialize controller: %w", err)
 	}
 
-	err = s.sp.Init()
-	if err != nil {
-		fmt.Printf("failed to initialize spotprice module\n")
-		return
+	log.Info("Thermia controller started", "version", version, "dryRun", dryRun, "threshold", s.threshold, "activeHours", s.activeHours)
+
+	// pending notifications are buffered so that senders do not block while the controller is busy
+	reload := make(chan struct{}, 1)
+	go watchConfig(configFile, reload)
+	overrideChanged := make(chan struct{}, 1)
+	go watchOverride(cfg.Override.File, overrideChanged)
+
+	a := newAPI(s.clock, cfg.API.Token, reload, overrideChanged)
+	var server *http.Server
+	if cfg.API.Listen != "" {
+		server = a.listen(cfg.API.Listen)
 	}
-	err = s.cs.Init(*dryRun)
-	if err != nil {
-		fmt.Printf("failed to initialize shelly module\n")
-		return
+
+	s.run(ctx, configFile, a, reload, overrideChanged)
+
+	log.Info("shutting down")
+	if server != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err = server.Shutdown(shutdownCtx); err != nil {
+			log.Warn("failed to stop HTTP API", "error", err)
+		}
+	}
+	// relays get their own time limit, so that a slow HTTP API shutdown does not prevent the safe state
+	relayCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err = s.shutdown(relayCtx); err != nil {
+		return fmt.Errorf("failed to set relays to safe state: %w", err)
 	}
+	log.Info("Thermia controller stopped")
+	return nil
+}
+
+// run is the control loop. Control cycle runs at startup, at the start of every hour and at schedule, calendar and
+// override changes, and immediately when configuration is reloaded, override changes or pushed indoor temperature
+// crosses a limit. Loop runs until ctx is cancelled, which also cancels in-flight requests.
+func (s *state) run(ctx context.Context, configFile string, a *api, reload, overrideChanged <-chan struct{}) {
+	timer := s.clock.NewTimer(time.Second)
+	defer timer.Stop()
 
-	fmt.Printf("Thermia controller started (version: %s, dryRun: %v, treshold: %0.2f, activeHours: %d)\n", version, *dryRun, s.threshold, s.activeHours)
+	indoorChanged := make(chan struct{}, 1)
+	s.watchIndoor(indoorChanged)
 
-	// every even hour
-	timer := time.NewTimer(time.Second)
+	// relays are checked in between control cycles
+	reconcileTimer := s.clock.NewTimer(time.Hour)
+	defer reconcileTimer.Stop()
+	s.scheduleReconcile(reconcileTimer)
 
 	for {
 		select {
-		case <-timer.C:
+		case <-ctx.Done():
+			return
+		case <-timer.C():
 			// Update prices
-			s.sp.UpdateSpotPrices()
+			s.sp.UpdateSpotPrices(ctx)
+			s.storePrices()
+
+			// Update outdoor temperature and active hours based on it
+			s.updateOutdoorTemperature(ctx)
+
+			// Update indoor temperature
+			s.updateIndoorTemperature(ctx)
 
 			// Control relay based on configuration and hourly price
-			if s.activeHours > 0 && s.threshold > 0 {
-				err = s.controlBasedOnThresholdAndActiveHours()
-				if err != nil {
-					err = s.controlBasedOnSchedule()
-				}
-			} else if s.activeHours > 0 {
-				err = s.controlBasedOnActiveHours()
-				if err != nil {
-					err = s.controlBasedOnSchedule()
-				}
-			} else if s.threshold > 0 {
-				err = s.controlBasedOnThreshold()
-				if err != nil {
-					err = s.controlBasedOnSchedule()
-				}
-			} else {
-				err = s.controlBasedOnSchedule()
+			a.publish(*s, s.control(ctx), s.clock.Now())
+
+			now := s.clock.Now()
+			timer.Reset(s.nextControl(now).Sub(now))
+		case <-reconcileTimer.C():
+			now := s.clock.Now()
+			if s.reconcile(ctx, now) {
+				// manual change is kept until the override ends
+				a.publish(*s, *s.applied, now)
+				timer.Reset(s.nextControl(now).Sub(now))
+			}
+			s.scheduleReconcile(reconcileTimer)
+		case <-reload:
+			if s.reload(configFile) != nil {
+				continue
 			}
-			if err != nil {
-				fmt.Printf("failed to control relay: %s\n", err.Error())
+			s.watchIndoor(indoorChanged)
+			s.scheduleReconcile(reconcileTimer)
+
+			// Re-evaluate the current hour with the new configuration, which may have new schedule, forced window or
+			// calendar boundaries
+			s.updateOutdoorTemperature(ctx)
+			s.updateIndoorTemperature(ctx)
+			a.publish(*s, s.control(ctx), s.clock.Now())
+
+			now := s.clock.Now()
+			timer.Reset(s.nextControl(now).Sub(now))
+		case <-indoorChanged:
+			s.updateIndoorTemperature(ctx)
+			a.publish(*s, s.control(ctx), s.clock.Now())
+
+			now := s.clock.Now()
+			timer.Reset(s.nextControl(now).Sub(now))
+		case <-overrideChanged:
+			if err := s.overrides.Reload(); err != nil {
+				log.Error("failed to reload override", "error", err)
+				continue
 			}
+			log.Info("override changed")
+			a.publish(*s, s.control(ctx), s.clock.Now())
 
-			timer.Reset(time.Now().Truncate(time.Hour).Add(time.Hour).Add(time.Second).Sub(time.Now()))
+			now := s.clock.Now()
+			timer.Reset(s.nextControl(now).Sub(now))
 		}
 	}
 }
 
-func getEnv() (s state, err error) {
-	threshold := os.Getenv("THRESHOLD")
-	if threshold != "" {
-		s.threshold, err = strconv.ParseFloat(threshold, 64)
-		if err != nil {
-			fmt.Printf("failed to parse float from environment variable (THRESHOLD): %s\n", err.Error())
-			return
+// nextControl returns the time of the next control cycle: the next full hour, schedule window or calendar period
+// boundary, or expiry of the manual override
+func (s state) nextControl(now time.Time) time.Time {
+	next := now.Truncate(time.Hour).Add(time.Hour)
+	for _, sch := range []schedule.Schedule{s.schedule, s.forcedOn, s.forcedOff} {
+		if change := sch.NextChange(now); !change.IsZero() && change.Before(next) {
+			next = change
 		}
 	}
+	if change := s.calendar.NextChange(now); !change.IsZero() && change.Before(next) {
+		next = change
+	}
+	if d, ok := s.applyOverride(now); ok && d.until.Before(next) {
+		next = d.until
+	}
+	return next.Add(time.Second)
+}
 
-	maxPrice := os.Getenv("MAX_PRICE")
-	if maxPrice != "" {
-		s.maxPrice, err = strconv.ParseFloat(maxPrice, 64)
-		if err != nil {
-			fmt.Printf("failed to parse float from environment variable (MAX_PRICE): %s\n", err.Error())
-			return
-		}
+// control decides the operating mode for the current hour, sets the relays accordingly and returns the decision
+func (s *state) control(ctx context.Context) decision {
+	now := s.clock.Now()
+	d := s.decide(now)
+	before, statusErr := s.auditRelays(ctx)
+	err := s.apply(ctx, d)
+	if err != nil {
+		log.Error("failed to control relay", "error", err)
 	}
+	s.applied = &d
+	s.logStatus(d)
+	s.updateMetrics(d, now, err)
+	s.record(ctx, now, d, before, statusErr, err)
+	return d
+}
 
-	activeHours := os.Getenv("ACTIVE_HOURS")
-	if activeHours != "" {
-		s.activeHours, err = strconv.Atoi(activeHours)
+// newState initializes the controller from configuration. Temperature sources of the previous state (if any) are
+// reused when their configuration has not changed. Sources created here are closed if initialization fails.
+func newState(cfg config.Config, dryRun bool, clk clock.Clock, prev *state) (s state, err error) {
+	defer func() {
 		if err != nil {
-			fmt.Printf("failed to parse int from environment variable (ACTIVE_HOURS): %s\n", err.Error())
-			return
+			closeSources(s.unusedSources(prev)...)
 		}
+	}()
+
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		log.Error("failed to set timezone", "timezone", cfg.Timezone, "error", err)
+		return
 	}
+	s.cfg = cfg
+	s.dryRun = dryRun
+	s.clock = clock.InLocation(clk, loc)
+	clk = s.clock
+
+	s.setStrategy(cfg.Strategy)
+	s.frost = newFrost(cfg.Frost)
 
-	schedule := os.Getenv("SCHEDULE")
-	if schedule == "" {
-		schedule = defaultSchedule
+	s.profiles, s.calendar, err = newProfiles(cfg, loc)
+	if err != nil {
+		return
 	}
 
-	s.schedule = make(map[int]bool)
-	scheduleString := strings.Split(schedule, ",")
-	for _, str := range scheduleString {
-		var hour int
-		hour, err = strconv.Atoi(str)
-		if err != nil {
-			fmt.Printf("failed to parse array of integers from environment variable (SCHEDULE): %s\n", err.Error())
-			return
-		}
-		if hour < 0 || hour > 24 {
-			err = errors.New("invalid hour")
-			fmt.Printf("failed to parse array of integers from environment variable (SCHEDULE): %s\n", err.Error())
-			return
-		}
-		s.schedule[hour] = true
+	s.schedule, err = schedule.Parse(cfg.Schedule)
+	if err != nil {
+		return
+	}
+	s.forcedOn, err = schedule.Parse(cfg.Forced.On)
+	if err != nil {
+		return
 	}
-	if len(s.schedule) > 24 {
-		err = errors.New("too many hours in schedule")
-		fmt.Printf("failed to parse array of integers from environment variable (SCHEDULE): %s\n", err.Error())
+	s.forcedOff, err = schedule.Parse(cfg.Forced.Off)
+	if err != nil {
 		return
 	}
 
-	tz := os.Getenv("TZ")
-	if tz != "" {
-		s.tz = defaultTimezone
+	if prev != nil && prev.cfg.Override == cfg.Override {
+		s.overrides = prev.overrides
+	} else {
+		s.overrides = newOverrides(cfg.Override.File)
 	}
+	s.overrides.SetBoostRelay(cfg.Relays.BoostURL != "")
 
-	return
-}
-
-// controlBasedOnThreshold controls heating based on threshold
-func (s state) controlBasedOnThreshold() (err error) {
-	now := time.Now()
-	price, err := s.sp.GetPrice(now)
-	if err != nil {
-		fmt.Printf("failed to control based on threshold: %s", err.Error())
-		return err
+	if prev != nil && prev.cfg.Audit == cfg.Audit {
+		s.audit = prev.audit
+	} else {
+		s.audit = newAudit(cfg.Audit)
 	}
 
-	fmt.Printf("control based on threshold (%.2f)\n", s.threshold)
-	fmt.Printf("hourly price [%s]: %.2f\n", time.Now().Format(time.RFC822), price)
-
-	if price <= s.threshold {
-		// heating ON / NORMAL mode (price is lower than the threshold)
-		fmt.Printf("Heating ON: price lower than the threshold: %0.2f (threshold: %0.2f)\n", price, s.threshold)
+	if prev != nil && prev.cfg.Prices == cfg.Prices {
+		s.prices = prev.prices
+	} else {
+		s.prices = newPriceStore(cfg.Prices.File)
+	}
 
-		err = s.cs.SwitchOff()
-		if err != nil {
-			fmt.Printf("failed to turn heat pump on: %s\n", err.Error())
-			return err
+	if prev != nil && reflect.DeepEqual(prev.cfg.Outdoor, cfg.Outdoor) {
+		s.outdoor = prev.outdoor
+		if s.outdoor.curve != nil {
+			s.activeHours = prev.activeHours
 		}
 	} else {
-		// heating OFF / ROOM LOWERING mode
-		fmt.Printf("Heating OFF: price higher than the threshold: %0.2f (threshold: %0.2f)\n", price, s.threshold)
-		err = s.cs.SwitchOn()
+		s.outdoor, err = newOutdoor(cfg.Outdoor, clk)
 		if err != nil {
-			fmt.Printf("failed to turn heat pump off / room lowering mode: %s\n", err.Error())
+			return
 		}
 	}
-	return nil
-}
-
-// controlBasedOnActiveHours controls heating based on activeHours (and maxPrice if set)
-func (s state) controlBasedOnActiveHours() (err error) {
-	now := time.Now()
-	price, err := s.sp.GetPrice(now)
-	if err != nil {
-		fmt.Printf("failed to control based on activeHours: %s", err.Error())
-		return err
-	}
-
-	if spotprice.IsCheapestHour(now.Hour(), s.sp.CheapestHours(s.activeHours)) {
-		// This is one of the cheapest hours
-		if price > s.maxPrice {
-			fmt.Printf("Heating OFF: this is one of the %d cheapest hours: %0.2f, but price is higher than maxPrice (%0.2f)\n", s.activeHours, price, s.maxPrice)
-		} else {
-			// Heating ON / NORMAL mode (this is one of the cheapest hours)
-			fmt.Printf("Heating ON: this is one of the %d cheapest hours: %0.2f\n", s.activeHours, price)
-			err = s.cs.SwitchOff()
-			if err != nil {
-				fmt.Printf("failed to turn heat pump on: %s\n", err.Error())
-				return err
-			}
-		}
+	if prev != nil && reflect.DeepEqual(prev.cfg.Indoor, cfg.Indoor) {
+		s.indoor = prev.indoor
 	} else {
-		fmt.Printf("Heating OFF: this is not one of the %d cheapest hours\n", s.activeHours)
-		err = s.cs.SwitchOn()
+		s.indoor, err = newIndoor(cfg.Indoor, clk)
 		if err != nil {
-			fmt.Printf("failed to turn heat pump off / room lowering mode: %s\n", err.Error())
+			return
 		}
 	}
 
-	return nil
-}
-
-// controlBasedOnThresholdAndActiveHours controls heating based on threshold and activeHours (and maxPrice if set)
-func (s state) controlBasedOnThresholdAndActiveHours() (err error) {
-	now := time.Now()
-	price, err := s.sp.GetPrice(now)
+	err = s.sp.InitWithConfig(spotprice.Config{
+		Token: cfg.Provider.Token,
+		Zone:  cfg.Provider.Zone,
+		Pricing: spotprice.Pricing{
+			VAT:      cfg.Pricing.VAT,
+			Margin:   cfg.Pricing.Margin,
+			Transfer: cfg.Pricing.Transfer,
+		},
+		Clock: clk,
+	})
 	if err != nil {
-		fmt.Printf("failed to control based on threshold and activehours: %s", err.Error())
-		return err
+		log.Error("failed to initialize spotprice module", "error", err)
+		return
 	}
+	if prev == nil {
+		s.loadPrices(clk.Now())
+	}
+	err = s.cs.InitWithConfig(control.Config{
+		URL:      cfg.Relays.URL,
+		BoostURL: cfg.Relays.BoostURL,
+		EVUURL:   cfg.Relays.EVUURL,
+	}, dryRun)
+	if err != nil {
+		log.Error("failed to initialize shelly module", "error", err)
+		return
+	}
+	return
+}
 
-	fmt.Printf("control based on threshold (%.2f) and active hours (%d)\n", s.threshold, s.activeHours)
-	fmt.Printf("hourly price [%s]: %.2f\n", time.Now().Format(time.RFC822), price)
-
-	if price <= s.threshold {
-		// heating ON / NORMAL mode (price is lower than the threshold)
-		err = s.cs.SwitchOff()
-		if err != nil {
-			fmt.Printf("failed to turn heat pump on: %s\n", err.Error())
-			return err
+// unusedSources returns the temperature sources of the state that are not used by the other state (all of them when
+// other is nil)
+func (s state) unusedSources(other *state) (sources []temperature.Source) {
+	for _, source := range []temperature.Source{s.indoor.source, s.outdoor.source} {
+		if source == nil {
+			continue
 		}
-	} else {
-		// price is higher than the threshold
-		if s.activeHours > 0 {
-			if spotprice.IsCheapestHour(now.Hour(), s.sp.CheapestHours(s.activeHours)) {
-				// This is one of the cheapest hours
-				if price > s.maxPrice {
-					fmt.Printf("Heating OFF: this is one of the %d cheapest hours: %0.2f, but price is higher than maxPrice (%0.2f)\n", s.activeHours, price, s.maxPrice)
-				} else {
-					// Heating ON / NORMAL mode (this is one of the cheapest hours)
-					fmt.Printf("Heating ON: price higher than threshold but this is one of the %d cheapest hours: %0.2f\n", s.activeHours, price)
-					err = s.cs.SwitchOff()
-					if err != nil {
-						fmt.Printf("failed to turn heat pump on: %s\n", err.Error())
-						return err
-					}
-				}
-			} else {
-				fmt.Printf("Heating OFF: price higher than threshold and this is not one of the %d cheapest hours\n", s.activeHours)
-				// heating OFF / ROOM LOWERING mode
-				err = s.cs.SwitchOn()
-				if err != nil {
-					fmt.Printf("failed to turn heat pump off / room lowering mode: %s\n", err.Error())
-				}
-			}
-		} else {
-			// heating OFF / ROOM LOWERING mode
-			err = s.cs.SwitchOn()
-			if err != nil {
-				fmt.Printf("failed to turn heat pump off / room lowering mode: %s\n", err.Error())
-			}
+		if other != nil && (source == other.indoor.source || source == other.outdoor.source) {
+			continue
 		}
+		sources = append(sources, source)
 	}
-	return nil
+	return sources
 }
 
-// controlBasedOnCron controls heating based on cron
-func (s state) controlBasedOnSchedule() (err error) {
-	now := time.Now()
-	price, _ := s.sp.GetPrice(now)
+// setStrategy sets the price strategy
+func (s *state) setStrategy(c config.Strategy) {
+	s.threshold = c.Threshold
+	s.maxPrice = c.MaxPrice
+	s.activeHours = c.ActiveHours
+	s.blockHours = c.BlockHours
+	s.relative = newRelativeThreshold(c.Relative)
+	s.alwaysOn = newAlwaysOnPrice(c.AlwaysOn)
+	s.preheat = newPreheat(s.cfg.Building, c.Preheat)
+}
 
-	fmt.Printf("control based on schedule\n")
+func newAlwaysOnPrice(c config.AlwaysOn) (a alwaysOnPrice) {
+	if c.Price == nil {
+		return
+	}
+	return alwaysOnPrice{enabled: true, price: *c.Price, boost: c.Boost}
+}
 
-	if s.schedule[now.Hour()] == true {
-		// Heating ON / NORMAL mode
-		fmt.Printf("Heating ON: schedule (price: %0.2f)\n", price)
-		err = s.cs.SwitchOff()
-		if err != nil {
-			fmt.Printf("failed to turn heat pump on: %s\n", err.Error())
-			return err
-		}
+func newRelativeThreshold(c config.Relative) (r relativeThreshold) {
+	if c.Percentile != nil {
+		r.mode, r.value = relativePercentile, *c.Percentile
+	} else if c.Median != nil {
+		r.mode, r.value = relativeMedian, *c.Median
 	} else {
-		// heating OFF / ROOM LOWERING mode
-		fmt.Printf("Heating OFF: schedule (price: %0.2f)\n", price)
-		err = s.cs.SwitchOn()
-		if err != nil {
-			fmt.Printf("failed to turn heat pump off / room lowering mode: %s\n", err.Error())
-		}
-
+		return
 	}
-	return nil
+	r.window = c.Window
+	r.floor = c.Floor
+	return
 }
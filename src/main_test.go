@@ -0,0 +1,1253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koovee/thermia/building"
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/pricestore"
+	"github.com/koovee/thermia/schedule"
+	"github.com/koovee/thermia/spotprice"
+	"github.com/koovee/thermia/temperature"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+// testNow is the time of the fake clock of the test state. Time is fixed, so that the tests do not depend on the time
+// of the day they are run.
+var testNow = time.Date(2024, time.January, 15, 12, 30, 0, 0, time.Local)
+
+// newTestState returns state with the hourly prices (EUR/MWh) of the day of testNow and a fake clock set to testNow
+func newTestState(prices []float64) state {
+	s := state{clock: clock.NewFake(testNow)}
+	s.sp.M = &sync.Mutex{}
+	s.sp.HourPrice = make(spotprice.HourPrices)
+	if prices != nil {
+		s.sp.HourPrice[testNow.Format(spotprice.DateLayout)] = prices
+	}
+	return s
+}
+
+func TestAlwaysOnPrice(t *testing.T) {
+	now := testNow
+	hourPrices := func(price float64) []float64 {
+		prices := make([]float64, 24)
+		for i := range prices {
+			// other hours are cheaper, so the current hour is not one of the cheapest hours
+			prices[i] = -500.0
+		}
+		prices[now.Hour()] = price
+		return prices
+	}
+
+	cases := map[string]struct {
+		hourPrices    []float64
+		alwaysOn      alwaysOnPrice
+		expectedMode  control.Mode
+		expectedBoost bool
+	}{
+		"Negative price without always on price": {hourPrices: hourPrices(-10.0), alwaysOn: alwaysOnPrice{}, expectedMode: control.Lowered},
+		"Negative price lower than always on":    {hourPrices: hourPrices(-10.0), alwaysOn: alwaysOnPrice{enabled: true, price: 0}, expectedMode: control.Normal},
+		"Near-zero price lower than always on":   {hourPrices: hourPrices(1.0), alwaysOn: alwaysOnPrice{enabled: true, price: 0.5}, expectedMode: control.Normal},
+		"Price higher than always on":            {hourPrices: hourPrices(10.0), alwaysOn: alwaysOnPrice{enabled: true, price: 0.5}, expectedMode: control.Lowered},
+		"Negative price with boost":              {hourPrices: hourPrices(-10.0), alwaysOn: alwaysOnPrice{enabled: true, price: 0, boost: true}, expectedMode: control.Normal, expectedBoost: true},
+		"No pricing available":                   {hourPrices: nil, alwaysOn: alwaysOnPrice{enabled: true, price: 0}, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(tc.hourPrices)
+		s.activeHours = 1
+		s.alwaysOn = tc.alwaysOn
+		d := s.decide(now)
+		if d.mode != tc.expectedMode || d.boost != tc.expectedBoost {
+			t.Fatalf("%s: decide\ngot:  %s (boost: %v)\nwant: %s (boost: %v)\n", k, d.mode, d.boost, tc.expectedMode, tc.expectedBoost)
+		}
+	}
+}
+
+func TestMaxPrice(t *testing.T) {
+	now := testNow
+	prices := make([]float64, 24)
+	for i := range prices {
+		// other hours are more expensive, so the current hour is the cheapest hour
+		prices[i] = 500.0
+	}
+	prices[now.Hour()] = 100.0 // 10 c/kWh
+
+	cases := map[string]struct {
+		maxPrice     float64
+		expectedMode control.Mode
+	}{
+		"MaxPrice not set":           {maxPrice: 0, expectedMode: control.Normal},
+		"Price lower than maxPrice":  {maxPrice: 15, expectedMode: control.Normal},
+		"Price higher than maxPrice": {maxPrice: 5, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(prices)
+		s.activeHours = 1
+		s.maxPrice = tc.maxPrice
+		d := s.decide(now)
+		if d.mode != tc.expectedMode {
+			t.Fatalf("%s: decide\ngot:  %s\nwant: %s\n", k, d.mode, tc.expectedMode)
+		}
+	}
+}
+
+func TestCheapestBlocks(t *testing.T) {
+	midnight := time.Date(testNow.Year(), testNow.Month(), testNow.Day(), 0, 0, 0, 0, testNow.Location())
+	yesterday := make([]float64, 24)
+	today := make([]float64, 24)
+	for i := range today {
+		yesterday[i], today[i] = 500.0, 500.0
+	}
+	// yesterday's block continues past midnight (22-02) and today's block is 10-14
+	yesterday[22], yesterday[23], today[0], today[1] = 0, 0, 10, 10
+	today[10], today[11], today[12], today[13] = 0, 0, 0, 0
+
+	cases := map[string]struct {
+		hour         int
+		expectedMode control.Mode
+	}{
+		"Yesterday's block after midnight": {hour: 1, expectedMode: control.Normal},
+		"After yesterday's block":          {hour: 2, expectedMode: control.Lowered},
+		"Today's block":                    {hour: 10, expectedMode: control.Normal},
+		"After today's block":              {hour: 14, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(today)
+		s.sp.HourPrice[midnight.AddDate(0, 0, -1).Format(spotprice.DateLayout)] = yesterday
+		s.blockHours = []int{4}
+		d := s.decide(midnight.Add(time.Duration(tc.hour)*time.Hour + 30*time.Minute))
+		if d.mode != tc.expectedMode {
+			t.Fatalf("%s: decide\ngot:  %s (%s)\nwant: %s\n", k, d.mode, d.reason, tc.expectedMode)
+		}
+	}
+}
+
+func TestIndoorTemperature(t *testing.T) {
+	now := testNow
+	cheap := make([]float64, 24)
+	expensive := make([]float64, 24)
+	for i := range expensive {
+		expensive[i] = 500.0
+	}
+
+	cases := map[string]struct {
+		hourPrices   []float64
+		indoor       indoor
+		expectedMode control.Mode
+	}{
+		"Cheap hour, temperature unknown":             {hourPrices: cheap, indoor: indoor{hasMax: true, max: 22}, expectedMode: control.Normal},
+		"Cheap hour, temperature higher than max":     {hourPrices: cheap, indoor: indoor{hasMax: true, max: 22, temperature: 23, known: true}, expectedMode: control.Lowered},
+		"Cheap hour, temperature between limits":      {hourPrices: cheap, indoor: indoor{hasMin: true, min: 19, hasMax: true, max: 22, temperature: 21, known: true}, expectedMode: control.Normal},
+		"Expensive hour, temperature lower than min":  {hourPrices: expensive, indoor: indoor{hasMin: true, min: 19, temperature: 18, known: true}, expectedMode: control.Normal},
+		"Expensive hour, temperature higher than min": {hourPrices: expensive, indoor: indoor{hasMin: true, min: 19, temperature: 20, known: true}, expectedMode: control.Lowered},
+		"Expensive hour, temperature unknown":         {hourPrices: expensive, indoor: indoor{hasMin: true, min: 19, temperature: 18}, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(tc.hourPrices)
+		s.threshold = 10
+		s.indoor = tc.indoor
+		d := s.decide(now)
+		if d.mode != tc.expectedMode {
+			t.Fatalf("%s: decide\ngot:  %s\nwant: %s\n", k, d.mode, tc.expectedMode)
+		}
+	}
+}
+
+func TestWatchIndoor(t *testing.T) {
+	s := newTestState(nil)
+	push := temperature.NewPushSource(time.Hour, s.clock)
+	s.indoor = indoor{source: push, hasMin: true, min: 19, hasMax: true, max: 23}
+	s.frost = frost{indoorLimit: 5}
+	changed := make(chan struct{}, 1)
+	s.watchIndoor(changed)
+
+	// cases are run in order, the previous temperature is carried over from the previous case
+	cases := []struct {
+		name             string
+		temperature      float64
+		expectedNotified bool
+	}{
+		{name: "First temperature between limits", temperature: 21},
+		{name: "Still between limits", temperature: 20},
+		{name: "Lower than minimum", temperature: 18.5, expectedNotified: true},
+		{name: "Still lower than minimum", temperature: 18},
+		{name: "Lower than frost limit", temperature: 4, expectedNotified: true},
+		{name: "Back between limits", temperature: 21, expectedNotified: true},
+		{name: "Higher than maximum", temperature: 23.5, expectedNotified: true},
+	}
+	for _, c := range cases {
+		push.Push(c.temperature)
+		notified := false
+		select {
+		case <-changed:
+			notified = true
+		default:
+		}
+		if notified != c.expectedNotified {
+			t.Fatalf("%s: notified\ngot:  %v\nwant: %v\n", c.name, notified, c.expectedNotified)
+		}
+	}
+}
+
+func TestFrostProtection(t *testing.T) {
+	now := testNow
+	expensive := make([]float64, 24)
+	for i := range expensive {
+		expensive[i] = 500.0
+	}
+
+	cases := map[string]struct {
+		outdoor       outdoor
+		indoor        indoor
+		frost         frost
+		expectedMode  control.Mode
+		expectedFrost bool
+	}{
+		"Outdoor temperature unknown":             {frost: frost{hasOutdoorLimit: true, outdoorLimit: -20, indoorLimit: 5}, expectedMode: control.Lowered},
+		"Outdoor temperature higher than limit":   {outdoor: outdoor{current: -10, known: true}, frost: frost{hasOutdoorLimit: true, outdoorLimit: -20, indoorLimit: 5}, expectedMode: control.Lowered},
+		"Outdoor temperature lower than limit":    {outdoor: outdoor{current: -25, known: true}, frost: frost{hasOutdoorLimit: true, outdoorLimit: -20, indoorLimit: 5}, expectedMode: control.Normal, expectedFrost: true},
+		"Outdoor limit not set":                   {outdoor: outdoor{current: -25, known: true}, frost: frost{indoorLimit: 5}, expectedMode: control.Lowered},
+		"Indoor temperature near freezing":        {indoor: indoor{temperature: 4, known: true}, frost: frost{indoorLimit: 5}, expectedMode: control.Normal, expectedFrost: true},
+		"Indoor temperature higher than limit":    {indoor: indoor{temperature: 15, known: true}, frost: frost{indoorLimit: 5}, expectedMode: control.Lowered},
+		"Indoor temperature above max but frozen": {indoor: indoor{hasMax: true, max: 3, temperature: 4, known: true}, frost: frost{indoorLimit: 5}, expectedMode: control.Normal, expectedFrost: true},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(expensive)
+		s.threshold = 10
+		s.outdoor = tc.outdoor
+		s.indoor = tc.indoor
+		s.frost = tc.frost
+		d := s.decide(now)
+		if d.mode != tc.expectedMode || d.frostProtection != tc.expectedFrost {
+			t.Fatalf("%s: decide\ngot:  %s (frost protection: %v)\nwant: %s (frost protection: %v)\n", k, d.mode, d.frostProtection, tc.expectedMode, tc.expectedFrost)
+		}
+	}
+}
+
+func TestPreheat(t *testing.T) {
+	now := testNow
+	// time constant 20 hours, heats up to 30 degrees above outdoor temperature
+	model := building.Model{HeatLoss: 0.2, Capacity: 4, Power: 6}
+	enabled := preheat{enabled: true, model: model, drop: 2, hours: 4, spread: 5}
+
+	// hourPrices returns today's and tomorrow's prices (EUR/MWh) with a 3 hour price spike starting in spikeIn hours
+	hourPrices := func(spikeIn int, spike float64) spotprice.HourPrices {
+		hp := make(spotprice.HourPrices)
+		start := now.Truncate(time.Hour)
+		for i := -24; i < 48; i++ {
+			h := start.Add(time.Duration(i) * time.Hour)
+			day := h.Format(spotprice.DateLayout)
+			if len(hp[day]) == 0 {
+				hp[day] = make([]float64, 24)
+			}
+			// price is higher than the threshold until the end of the spike
+			price := 120.0
+			if i >= spikeIn && i < spikeIn+3 {
+				price = spike
+			} else if i >= spikeIn+3 {
+				price = 50
+			}
+			hp[day][h.Hour()] = price
+		}
+		return hp
+	}
+
+	// at 0 °C the house coasts 2 hours and needs 2.5 hours of heating to coast through the spike, at 15 °C the house
+	// coasts through the spike without pre-heating and at -10 °C heating power is not enough to reach the target
+	cases := map[string]struct {
+		hourPrices   spotprice.HourPrices
+		preheat      preheat
+		outdoor      outdoor
+		expectedMode control.Mode
+	}{
+		"Pre-heating disabled":          {hourPrices: hourPrices(1, 300), preheat: preheat{}, expectedMode: control.Lowered},
+		"Spike in 1 hour":               {hourPrices: hourPrices(1, 300), preheat: enabled, expectedMode: control.Normal},
+		"Spike in 3 hours":              {hourPrices: hourPrices(3, 300), preheat: enabled, expectedMode: control.Normal},
+		"Spike in 4 hours":              {hourPrices: hourPrices(4, 300), preheat: enabled, expectedMode: control.Lowered},
+		"Spike smaller than spread":     {hourPrices: hourPrices(1, 150), preheat: enabled, expectedMode: control.Lowered},
+		"House can not coast (no drop)": {hourPrices: hourPrices(1, 300), preheat: preheat{enabled: true, model: model, drop: 0, hours: 4, spread: 5}, expectedMode: control.Lowered},
+		"Mild weather":                  {hourPrices: hourPrices(1, 300), preheat: enabled, outdoor: outdoor{current: 15, known: true}, expectedMode: control.Lowered},
+		"Spike in 4 hours, cold":        {hourPrices: hourPrices(4, 300), preheat: enabled, outdoor: outdoor{current: -10, known: true}, expectedMode: control.Normal},
+		"Spike in 5 hours, cold":        {hourPrices: hourPrices(5, 300), preheat: enabled, outdoor: outdoor{current: -10, known: true}, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(nil)
+		s.sp.HourPrice = tc.hourPrices
+		s.threshold = 10
+		s.preheat = tc.preheat
+		s.outdoor = tc.outdoor
+		d := s.decide(now)
+		if d.mode != tc.expectedMode {
+			t.Fatalf("%s: decide\ngot:  %s (%s)\nwant: %s\n", k, d.mode, d.reason, tc.expectedMode)
+		}
+	}
+
+	// pre-heating hours follow from the building model
+	s := newTestState(nil)
+	s.preheat = enabled
+	if hours, target := s.preheatHours(1, 3); hours != 3 || math.Abs(target-model.StartTemperature(19, 0, 3)) > 1e-9 {
+		t.Fatalf("preheatHours\ngot:  %d (target: %v)\nwant: %d (target: %v)\n", hours, target, 3, model.StartTemperature(19, 0, 3))
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "thermia.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatalf("failed to write configuration file: %s", err.Error())
+		}
+	}
+
+	write("provider: {token: test}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\nstrategy: {threshold: 10}\n")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("config.Load() did not succeed: %s", err.Error())
+	}
+	s, err := newState(cfg, true, clock.Real, nil)
+	if err != nil {
+		t.Fatalf("newState() did not succeed: %s", err.Error())
+	}
+	s.sp.HourPrice[time.Now().Format(spotprice.DateLayout)] = make([]float64, 24)
+
+	// valid configuration is swapped in and prices are kept
+	write("provider: {token: test}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\nstrategy: {threshold: 12, activeHours: 4}\n")
+	if err = s.reload(path); err != nil {
+		t.Fatalf("reload() did not succeed: %s", err.Error())
+	}
+	if s.threshold != 12 || s.activeHours != 4 || !s.dryRun {
+		t.Fatalf("reload() did not apply configuration: threshold: %v, activeHours: %d, dryRun: %v", s.threshold, s.activeHours, s.dryRun)
+	}
+	if len(s.sp.HourPrice) != 1 {
+		t.Fatalf("reload() did not keep prices")
+	}
+
+	// invalid configuration is not used
+	write("provider: {token: test}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\nstrategy: {threshold: -1}\n")
+	if err = s.reload(path); err == nil {
+		t.Fatalf("reload() should have failed, but it succeeded")
+	}
+	if s.threshold != 12 {
+		t.Fatalf("reload() changed configuration although it was invalid: threshold: %v", s.threshold)
+	}
+
+	// prices are dropped when bidding zone changes
+	write("provider: {token: test, zone: 10YSE-1--------K}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\n")
+	if err = s.reload(path); err != nil {
+		t.Fatalf("reload() did not succeed: %s", err.Error())
+	}
+	if len(s.sp.HourPrice) != 0 {
+		t.Fatalf("reload() kept prices of the previous bidding zone")
+	}
+
+	// replaced temperature source is closed and an unchanged one is kept open
+	outdoor, indoor := &closingSource{}, &closingSource{}
+	s.outdoor.source, s.indoor.source = outdoor, indoor
+	write("provider: {token: test, zone: 10YSE-1--------K}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\nindoor: {source: http, url: \"http://127.0.0.1/status\"}\n")
+	if err = s.reload(path); err != nil {
+		t.Fatalf("reload() did not succeed: %s", err.Error())
+	}
+	if !indoor.closed || outdoor.closed || s.outdoor.source != outdoor {
+		t.Fatalf("reload() did not close the replaced source: indoor closed: %v, outdoor closed: %v", indoor.closed, outdoor.closed)
+	}
+}
+
+// closingSource is a temperature source that records whether it is closed
+type closingSource struct {
+	closed bool
+}
+
+func (c *closingSource) Current(context.Context) (float64, error) { return 20, nil }
+
+func (c *closingSource) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestForcedWindows(t *testing.T) {
+	now := testNow
+	cheap := make([]float64, 24)
+	expensive := make([]float64, 24)
+	for i := range expensive {
+		expensive[i] = 500.0
+	}
+	allDay, _ := schedule.Parse([]string{"00:00-24:00"})
+
+	cases := map[string]struct {
+		hourPrices   []float64
+		schedule     schedule.Schedule
+		forcedOn     schedule.Schedule
+		forcedOff    schedule.Schedule
+		expectedMode control.Mode
+	}{
+		"Expensive hour without forced windows": {hourPrices: expensive, expectedMode: control.Lowered},
+		"Expensive hour in forced on window":    {hourPrices: expensive, forcedOn: allDay, expectedMode: control.Normal},
+		"Cheap hour in forced off window":       {hourPrices: cheap, forcedOff: allDay, expectedMode: control.Lowered},
+		"Forced on and off windows overlap":     {hourPrices: cheap, forcedOn: allDay, forcedOff: allDay, expectedMode: control.Normal},
+		"Fallback schedule without prices":      {hourPrices: nil, schedule: allDay, expectedMode: control.Normal},
+		"Forced off window without prices":      {hourPrices: nil, schedule: allDay, forcedOff: allDay, expectedMode: control.Lowered},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(tc.hourPrices)
+		s.threshold = 10
+		s.schedule = tc.schedule
+		s.forcedOn = tc.forcedOn
+		s.forcedOff = tc.forcedOff
+		d := s.decide(now)
+		if d.mode != tc.expectedMode {
+			t.Fatalf("%s: decide\ngot:  %s\nwant: %s\n", k, d.mode, tc.expectedMode)
+		}
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	now := testNow
+	today := now.Format("2006-01-02")
+	tomorrow := now.AddDate(0, 0, 1).Format("2006-01-02")
+	prices := make([]float64, 24)
+	for i := range prices {
+		prices[i] = 50.0 // 5 c/kWh
+	}
+
+	cfg := config.Default()
+	cfg.Profiles = map[string]config.Profile{
+		"away":    {Strategy: config.Strategy{Threshold: 1}, EVUStop: true, ReturnHours: 48},
+		"holiday": {Strategy: config.Strategy{Threshold: 1}},
+	}
+
+	cases := map[string]struct {
+		periods         []config.Period
+		expectedMode    control.Mode
+		expectedProfile string
+	}{
+		"No calendar periods":            {expectedMode: control.Normal},
+		"Holiday":                        {periods: []config.Period{{From: today, Profile: "holiday"}}, expectedMode: control.Lowered, expectedProfile: "holiday"},
+		"Away with EVU STOP":             {periods: []config.Period{{From: today, To: "2999-01-01", Profile: "away"}}, expectedMode: control.EVUStop, expectedProfile: "away"},
+		"Returning home within 48 hours": {periods: []config.Period{{From: today, To: tomorrow, Profile: "away"}}, expectedMode: control.Normal},
+	}
+
+	for k, tc := range cases {
+		s := newTestState(prices)
+		s.threshold = 10
+		cfg.Calendar.Periods = tc.periods
+		var err error
+		s.profiles, s.calendar, err = newProfiles(cfg, now.Location())
+		if err != nil {
+			t.Fatalf("%s: newProfiles did not succeed: %s", k, err.Error())
+		}
+		d := s.decide(now)
+		if d.mode != tc.expectedMode || d.profile != tc.expectedProfile {
+			t.Fatalf("%s: decide\ngot:  %s (%q)\nwant: %s (%q)\n", k, d.mode, d.profile, tc.expectedMode, tc.expectedProfile)
+		}
+	}
+}
+
+func TestOverride(t *testing.T) {
+	prices := make([]float64, 24) // cheap hours, heating ON without override
+	minusTen := -10.0
+
+	cases := map[string]struct {
+		mode          string
+		boost         bool
+		duration      time.Duration
+		frostLimit    *float64
+		expectedMode  control.Mode
+		expectedBoost bool
+	}{
+		"No override":           {expectedMode: control.Normal},
+		"Lowered override":      {mode: "lowered", duration: time.Hour, expectedMode: control.Lowered},
+		"EVU STOP override":     {mode: "evustop", duration: time.Hour, expectedMode: control.EVUStop},
+		"Boost override":        {mode: "normal", boost: true, duration: time.Hour, expectedMode: control.Normal, expectedBoost: true},
+		"Expired override":      {mode: "lowered", duration: time.Nanosecond, expectedMode: control.Normal},
+		"Frost protection wins": {mode: "evustop", duration: time.Hour, frostLimit: &minusTen, expectedMode: control.Normal},
+		"Cleared override":      {mode: "clear", expectedMode: control.Normal},
+	}
+
+	for k, tc := range cases {
+		path := filepath.Join(t.TempDir(), "override.json")
+		if tc.mode == "clear" {
+			if _, err := setOverride(newOverrides(path), "lowered", false, testNow, time.Hour, true); err != nil {
+				t.Fatalf("%s: setOverride did not succeed: %s", k, err.Error())
+			}
+		}
+		if tc.mode != "" {
+			store := newOverrides(path)
+			store.SetBoostRelay(true)
+			if _, err := setOverride(store, tc.mode, tc.boost, testNow, tc.duration, true); err != nil {
+				t.Fatalf("%s: setOverride did not succeed: %s", k, err.Error())
+			}
+		}
+
+		s := newTestState(prices)
+		s.threshold = 10
+		s.overrides = newOverrides(path)
+		s.frost = newFrost(config.Frost{OutdoorLimit: tc.frostLimit})
+		s.outdoor.known, s.outdoor.current = true, -20
+		d := s.decide(testNow.Add(time.Millisecond))
+		if d.mode != tc.expectedMode || d.boost != tc.expectedBoost {
+			t.Fatalf("%s: decide\ngot:  %s (boost: %v)\nwant: %s (boost: %v)\n", k, d.mode, d.boost, tc.expectedMode, tc.expectedBoost)
+		}
+	}
+
+	if _, err := setOverride(newOverrides(""), "evustop", false, testNow, time.Hour, false); err == nil {
+		t.Fatalf("EVU STOP override without EVU relay should have failed, but it succeeded")
+	}
+	if _, err := setOverride(newOverrides(""), "normal", true, testNow, time.Hour, true); err == nil {
+		t.Fatalf("boost override without boost relay should have failed, but it succeeded")
+	}
+}
+
+func TestAPI(t *testing.T) {
+	now := testNow
+	prices := make([]float64, 24)
+	for i := range prices {
+		prices[i] = 500.0
+	}
+	s := newTestState(prices)
+	s.threshold = 10
+	s.overrides = newOverrides(filepath.Join(t.TempDir(), "override.json"))
+
+	reload, overrideChanged := make(chan struct{}, 1), make(chan struct{}, 1)
+	a := newAPI(clock.Real, "", reload, overrideChanged)
+	d := s.decide(now)
+	a.publish(s, d, now)
+	s.updateMetrics(d, now, nil)
+	server := httptest.NewServer(a.handler())
+	defer server.Close()
+
+	// requestWith sends a request with the given content type and API token
+	requestWith := func(method, path, body, contentType, token string) *http.Response {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("failed to create request: %s", err.Error())
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s failed: %s", method, path, err.Error())
+		}
+		return resp
+	}
+	request := func(method, path, body string) *http.Response {
+		return requestWith(method, path, body, "application/json", "")
+	}
+
+	cases := map[string]struct {
+		method       string
+		path         string
+		body         string
+		contentType  string
+		expectedCode int
+	}{
+		"Status":                 {method: http.MethodGet, path: "/api/status", expectedCode: http.StatusOK},
+		"Prices":                 {method: http.MethodGet, path: "/api/prices", expectedCode: http.StatusOK},
+		"Plan":                   {method: http.MethodGet, path: "/api/plan", expectedCode: http.StatusOK},
+		"Relays":                 {method: http.MethodGet, path: "/api/relays", expectedCode: http.StatusOK},
+		"Status with POST":       {method: http.MethodPost, path: "/api/status", expectedCode: http.StatusMethodNotAllowed},
+		"Override":               {method: http.MethodPost, path: "/api/override", body: `{"mode": "normal", "duration": "2h"}`, expectedCode: http.StatusOK},
+		"Boost without relay":    {method: http.MethodPost, path: "/api/override", body: `{"mode": "normal", "boost": true}`, expectedCode: http.StatusBadRequest},
+		"Override with bad mode": {method: http.MethodPost, path: "/api/override", body: `{"mode": "hot"}`, expectedCode: http.StatusBadRequest},
+		"EVU STOP without relay": {method: http.MethodPost, path: "/api/override", body: `{"mode": "evustop"}`, expectedCode: http.StatusBadRequest},
+		"Override with bad body": {method: http.MethodPost, path: "/api/override", body: `mode=normal`, expectedCode: http.StatusBadRequest},
+		"Clear override":         {method: http.MethodDelete, path: "/api/override", expectedCode: http.StatusNoContent},
+		"Reload":                 {method: http.MethodPost, path: "/api/reload", expectedCode: http.StatusAccepted},
+		"Reload with GET":        {method: http.MethodGet, path: "/api/reload", expectedCode: http.StatusMethodNotAllowed},
+		"Override from a form":   {method: http.MethodPost, path: "/api/override", body: `{"mode": "normal"}`, contentType: "application/x-www-form-urlencoded", expectedCode: http.StatusUnsupportedMediaType},
+		"Reload from a form":     {method: http.MethodPost, path: "/api/reload", contentType: "text/plain", expectedCode: http.StatusUnsupportedMediaType},
+	}
+
+	for k, tc := range cases {
+		contentType := tc.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		resp := requestWith(tc.method, tc.path, tc.body, contentType, "")
+		resp.Body.Close()
+		if resp.StatusCode != tc.expectedCode {
+			t.Fatalf("%s: %s %s\ngot:  %d\nwant: %d\n", k, tc.method, tc.path, resp.StatusCode, tc.expectedCode)
+		}
+	}
+
+	// status and plan reflect the state
+	var status apiStatus
+	resp := request(http.MethodGet, "/api/status", "")
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if status.Mode != control.Lowered || status.Price == nil || *status.Price != 50 || status.Strategy == "" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	var plan []planHour
+	resp = request(http.MethodGet, "/api/plan", "")
+	json.NewDecoder(resp.Body).Decode(&plan)
+	resp.Body.Close()
+	if len(plan) != 24-now.Hour() || plan[0].Mode != control.Lowered {
+		t.Fatalf("unexpected plan: %+v", plan)
+	}
+
+	var history []apiDecision
+	resp = request(http.MethodGet, "/api/history", "")
+	json.NewDecoder(resp.Body).Decode(&history)
+	resp.Body.Close()
+	if len(history) != 1 || history[0].Mode != control.Lowered {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+
+	resp = request(http.MethodGet, "/metrics", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, e := range []string{`thermia_mode{mode="LOWERED"} 1`, `thermia_relay_on{relay="relay"} 1`, "thermia_price 50\n", "thermia_spotprice_hours_since_last_fetch "} {
+		if !strings.Contains(string(body), e) {
+			t.Fatalf("metrics do not contain %q:\n%s", e, body)
+		}
+	}
+
+	// override is set and the main loop is notified
+	resp = request(http.MethodPost, "/api/override", `{"mode": "lowered", "duration": "1h"}`)
+	resp.Body.Close()
+	select {
+	case <-overrideChanged:
+	default:
+		t.Fatalf("override change was not notified")
+	}
+	if o, ok := s.overrides.Active(time.Now()); !ok || o.Mode != control.Lowered {
+		t.Fatalf("override was not set: %+v", o)
+	}
+	select {
+	case <-reload:
+	default:
+		t.Fatalf("reload was not notified")
+	}
+
+	// requests changing the state require the API token when it is configured
+	a.token = "secret"
+	for k, tc := range map[string]struct {
+		method       string
+		path         string
+		token        string
+		expectedCode int
+	}{
+		"Status without token":         {method: http.MethodGet, path: "/api/status", expectedCode: http.StatusOK},
+		"Override without token":       {method: http.MethodPost, path: "/api/override", expectedCode: http.StatusUnauthorized},
+		"Override with wrong token":    {method: http.MethodPost, path: "/api/override", token: "guess", expectedCode: http.StatusUnauthorized},
+		"Clear override without token": {method: http.MethodDelete, path: "/api/override", expectedCode: http.StatusUnauthorized},
+		"Reload without token":         {method: http.MethodPost, path: "/api/reload", expectedCode: http.StatusUnauthorized},
+		"Reload with token":            {method: http.MethodPost, path: "/api/reload", token: "secret", expectedCode: http.StatusAccepted},
+		"Temperature without push":     {method: http.MethodGet, path: "/api/temperature?temp=21.5&token=secret", expectedCode: http.StatusServiceUnavailable},
+	} {
+		resp := requestWith(tc.method, tc.path, `{"mode": "normal"}`, "application/json", tc.token)
+		resp.Body.Close()
+		if resp.StatusCode != tc.expectedCode {
+			t.Fatalf("%s: %s %s\ngot:  %d\nwant: %d\n", k, tc.method, tc.path, resp.StatusCode, tc.expectedCode)
+		}
+	}
+
+	// indoor temperature is pushed as Shelly H&T action URL (GET with query parameters)
+	push := temperature.NewPushSource(time.Hour, s.clock)
+	s.indoor.source = push
+	a.publish(s, d, now)
+	for k, tc := range map[string]struct {
+		path         string
+		expectedCode int
+	}{
+		"Temperature without token":  {path: "/api/temperature?temp=20", expectedCode: http.StatusUnauthorized},
+		"Temperature with bad value": {path: "/api/temperature?temp=warm&token=secret", expectedCode: http.StatusBadRequest},
+		"Temperature":                {path: "/api/temperature?hum=45&temp=21.5&id=shellyht-1&token=secret", expectedCode: http.StatusNoContent},
+	} {
+		resp := requestWith(http.MethodGet, tc.path, "", "", "")
+		resp.Body.Close()
+		if resp.StatusCode != tc.expectedCode {
+			t.Fatalf("%s: GET %s\ngot:  %d\nwant: %d\n", k, tc.path, resp.StatusCode, tc.expectedCode)
+		}
+	}
+	if temp, err := push.Current(context.Background()); err != nil || temp != 21.5 {
+		t.Fatalf("pushed temperature\ngot:  %v (%v)\nwant: %v\n", temp, err, 21.5)
+	}
+}
+
+// newTestRelay returns a fake Shelly relay (initially off)
+func newTestRelay() *httptest.Server {
+	var m sync.Mutex
+	on, source := false, "http"
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		if turn := r.URL.Query().Get("turn"); turn != "" {
+			// source simulates changes made on the relay, e.g. with the button ("input")
+			on, source = turn == "on", "http"
+			if src := r.URL.Query().Get("source"); src != "" {
+				source = src
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ison": on, "source": source})
+	}))
+}
+
+func TestAudit(t *testing.T) {
+	relay := newTestRelay()
+	defer relay.Close()
+
+	allDay, _ := schedule.Parse([]string{"00:00-24:00"})
+	s := newTestState(make([]float64, 24)) // cheap hours, heating ON without forced off window
+	s.threshold = 10
+	s.forcedOff = allDay
+	s.audit = newAudit(config.Audit{File: filepath.Join(t.TempDir(), "audit.jsonl")})
+	if err := s.cs.InitWithConfig(control.Config{URL: relay.URL}, false); err != nil {
+		t.Fatalf("failed to initialize relay: %s", err.Error())
+	}
+
+	now := testNow
+	d := s.control(context.Background())
+	if d.mode != control.Lowered || len(d.constraints) != 1 || d.constraints[0] != "forced window" {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+
+	var b strings.Builder
+	if err := queryAudit(&b, s.audit, now.Format(hourLayout), now.Location()); err != nil {
+		t.Fatalf("queryAudit() did not succeed: %s", err.Error())
+	}
+	for _, e := range []string{"LOWERED", "strategy: threshold (10.00)", "reason: forced off window", "constraints: forced window", "relays: relay off -> on"} {
+		if !strings.Contains(b.String(), e) {
+			t.Errorf("audit query does not contain %q:\n%s", e, b.String())
+		}
+	}
+
+	cases := map[string]struct {
+		timeRange string
+		expected  time.Duration
+		fail      bool
+	}{
+		"Hour":       {timeRange: "2022-12-01T07:00", expected: time.Hour},
+		"Day":        {timeRange: "2022-12-01", expected: 24 * time.Hour},
+		"Range":      {timeRange: "2022-12-01..2022-12-07", expected: 7 * 24 * time.Hour},
+		"Hour range": {timeRange: "2022-12-01T07:00..2022-12-01T09:00", expected: 2 * time.Hour},
+		"Invalid":    {timeRange: "yesterday", fail: true},
+	}
+	for k, tc := range cases {
+		from, to, err := parseTimeRange(tc.timeRange, time.UTC)
+		if (err != nil) != tc.fail {
+			t.Fatalf("%s: parseTimeRange() error: %v", k, err)
+		}
+		if !tc.fail && to.Sub(from) != tc.expected {
+			t.Errorf("%s: expected %s, got %s", k, tc.expected, to.Sub(from))
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Fatalf("failed to load location: %s", err.Error())
+	}
+	relay := newTestRelay()
+	defer relay.Close()
+
+	start := time.Date(2022, 12, 1, 17, 30, 0, 0, loc)
+	fake := clock.NewFake(start)
+	s := newTestState(nil)
+	s.clock = fake
+	s.threshold = 3
+	if err = s.sp.InitWithConfig(spotprice.Config{Token: "token", URL: "http://127.0.0.1:0", Clock: fake}); err != nil {
+		t.Fatalf("failed to initialize spot prices: %s", err.Error())
+	}
+	if err = s.cs.InitWithConfig(control.Config{URL: relay.URL}, false); err != nil {
+		t.Fatalf("failed to initialize relay: %s", err.Error())
+	}
+	// prices are known for today and tomorrow (EUR/MWh): day of month * 10 + hour
+	for _, day := range []time.Time{start, start.AddDate(0, 0, 1)} {
+		prices := make([]float64, 24)
+		for hour := range prices {
+			prices[hour] = float64(day.Day()*10 + hour)
+		}
+		s.sp.HourPrice[day.Format(spotprice.DateLayout)] = prices
+	}
+
+	a := newAPI(fake, "", make(chan struct{}, 1), make(chan struct{}, 1))
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.run(ctx, "", a, nil, nil)
+		close(done)
+	}()
+
+	// the first cycle runs a second after startup and the next ones a second after the start of every hour
+	fake.BlockUntil(1)
+	fake.Advance(time.Second)
+	expected := []struct {
+		time time.Time
+		mode control.Mode
+	}{
+		{time: time.Date(2022, 12, 1, 17, 30, 1, 0, loc), mode: control.Normal},
+		{time: time.Date(2022, 12, 1, 18, 0, 1, 0, loc), mode: control.Normal},
+		{time: time.Date(2022, 12, 1, 19, 0, 1, 0, loc), mode: control.Normal},
+		{time: time.Date(2022, 12, 1, 20, 0, 1, 0, loc), mode: control.Normal},
+		{time: time.Date(2022, 12, 1, 21, 0, 1, 0, loc), mode: control.Lowered},
+		{time: time.Date(2022, 12, 1, 22, 0, 1, 0, loc), mode: control.Lowered},
+		{time: time.Date(2022, 12, 1, 23, 0, 1, 0, loc), mode: control.Lowered},
+		{time: time.Date(2022, 12, 2, 0, 0, 1, 0, loc), mode: control.Normal}, // tomorrow's prices after day rollover
+	}
+	for i, e := range expected {
+		fake.BlockUntil(1)
+		a.m.Lock()
+		history := append([]apiDecision(nil), a.history...)
+		a.m.Unlock()
+		if len(history) != i+1 || !history[i].Time.Equal(e.time) || history[i].Mode != e.mode {
+			t.Fatalf("cycle %d: expected %s at %s, got %+v", i, e.mode, e.time, history)
+		}
+		if i+1 < len(expected) && !fake.Next().Equal(expected[i+1].time) {
+			t.Fatalf("cycle %d: expected the next cycle at %s, got %s", i, expected[i+1].time, fake.Next())
+		}
+		fake.Set(fake.Next())
+	}
+
+	stop()
+	<-done
+}
+
+func TestShutdown(t *testing.T) {
+	relay := newTestRelay()
+	defer relay.Close()
+
+	cases := map[string]struct {
+		mode     string
+		expected control.Mode
+		fail     bool
+	}{
+		"Normal":      {mode: "normal", expected: control.Normal},
+		"Lowered":     {mode: "LOWERED", expected: control.Lowered},
+		"Keep":        {mode: config.ShutdownKeep, expected: control.Lowered},
+		"Invalid":     {mode: "off", expected: control.Lowered, fail: true},
+		"Not defined": {mode: "", expected: control.Lowered},
+	}
+	for k, c := range cases {
+		s := newTestState(nil)
+		s.cfg.Shutdown.Mode = c.mode
+		if err := s.cs.InitWithConfig(control.Config{URL: relay.URL}, false); err != nil {
+			t.Fatalf("failed to initialize relay: %s", err.Error())
+		}
+		// room lowering when the controller stops
+		if err := s.cs.Set(context.Background(), control.Lowered); err != nil {
+			t.Fatalf("failed to set relay: %s", err.Error())
+		}
+
+		if err := s.shutdown(context.Background()); (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+		status, err := s.cs.Status(context.Background())
+		if err != nil {
+			t.Fatalf("failed to get relay status: %s", err.Error())
+		}
+		if status.Mode != c.expected {
+			t.Errorf("%s: expected %s after shutdown, got %s", k, c.expected, status.Mode)
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	relay := newTestRelay()
+	defer relay.Close()
+
+	s := newTestState(make([]float64, 24))
+	s.threshold = 10
+	s.audit = newAudit(config.Audit{File: filepath.Join(t.TempDir(), "audit.jsonl")})
+	if err := s.cs.InitWithConfig(control.Config{URL: relay.URL}, false); err != nil {
+		t.Fatalf("failed to initialize relay: %s", err.Error())
+	}
+	now := testNow
+	s.reconcile(context.Background(), now) // nothing to reconcile before the first control cycle
+	if d := s.control(context.Background()); d.mode != control.Normal {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+
+	// relay is turned on from the Shelly app
+	resp, err := http.Get(relay.URL + "?turn=on")
+	if err != nil {
+		t.Fatalf("failed to turn relay on: %s", err.Error())
+	}
+	resp.Body.Close()
+
+	s.reconcile(context.Background(), now)
+	s.reconcile(context.Background(), now) // relays are in the decided state again
+	if status, err := s.cs.Status(context.Background()); err != nil || status.Mode != control.Normal {
+		t.Errorf("reconcile() did not apply the decision again: %+v (%v)", status, err)
+	}
+	events, err := s.audit.Query(now.Add(-time.Minute), now.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("failed to query audit log: %s", err.Error())
+	}
+	if len(events) != 2 || !strings.HasPrefix(events[1].Reason, "relays changed outside the controller (relay on (http))") {
+		t.Errorf("unexpected audit events: %+v", events)
+	}
+
+	// boost without boost relay is not drift (e.g. always on price with boost)
+	s.applied.boost = true
+	s.reconcile(context.Background(), now)
+	if events, err = s.audit.Query(now.Add(-time.Minute), now.Add(time.Minute)); err != nil || len(events) != 2 {
+		t.Errorf("reconcile() reported drift of boost without boost relay: %+v (%v)", events, err)
+	}
+
+	// relays are not touched in dry run
+	s.dryRun = true
+	if resp, err = http.Get(relay.URL + "?turn=on"); err == nil {
+		resp.Body.Close()
+	}
+	s.reconcile(context.Background(), now)
+	if status, err := s.cs.Status(context.Background()); err != nil || status.Mode != control.Lowered {
+		t.Errorf("reconcile() changed relays in dry run: %+v (%v)", status, err)
+	}
+}
+
+func TestScheduleReconcile(t *testing.T) {
+	fake := clock.NewFake(testNow)
+	s := newTestState(nil)
+	timer := fake.NewTimer(time.Hour)
+
+	// interval changes on reload: disabled, enabled, changed and disabled again
+	for _, interval := range []time.Duration{0, time.Minute, 5 * time.Minute, 0} {
+		s.cfg.Relays.ReconcileInterval = interval
+		s.scheduleReconcile(timer)
+		expected := time.Time{}
+		if interval > 0 {
+			expected = testNow.Add(interval)
+		}
+		if !fake.Next().Equal(expected) {
+			t.Fatalf("interval %s: next reconciliation\ngot:  %v\nwant: %v\n", interval, fake.Next(), expected)
+		}
+	}
+
+	// timer that has fired is not left pending when reconciliation is disabled
+	s.cfg.Relays.ReconcileInterval = time.Minute
+	s.scheduleReconcile(timer)
+	fake.Advance(time.Minute)
+	s.cfg.Relays.ReconcileInterval = 0
+	s.scheduleReconcile(timer)
+	select {
+	case <-timer.C():
+		t.Fatalf("disabled reconciliation should not be pending")
+	default:
+	}
+}
+
+func TestManualChange(t *testing.T) {
+	now := testNow
+	minusTwenty := -20.0
+	next := now.Truncate(time.Hour).Add(time.Hour)
+	cases := map[string]struct {
+		source        string
+		respectManual bool
+		timeout       time.Duration
+		outdoor       float64
+		expectedMode  control.Mode
+		expectedUntil time.Time
+	}{
+		"Button":           {source: "input", respectManual: true, expectedMode: control.Lowered, expectedUntil: next},
+		"App with timeout": {source: "cloud", respectManual: true, timeout: 2 * time.Hour, expectedMode: control.Lowered, expectedUntil: now.Add(2 * time.Hour)},
+		"Not respected":    {source: "input", respectManual: false, expectedMode: control.Normal},
+		"Not manual":       {source: "http", respectManual: true, expectedMode: control.Normal},
+		"Frost protection": {source: "input", respectManual: true, outdoor: -30, expectedMode: control.Normal},
+	}
+	for k, tc := range cases {
+		relay := newTestRelay()
+		s := newTestState(make([]float64, 24))
+		s.threshold = 10
+		s.cfg.Relays.RespectManual = tc.respectManual
+		s.cfg.Relays.ManualTimeout = tc.timeout
+		s.frost = newFrost(config.Frost{OutdoorLimit: &minusTwenty, IndoorLimit: 5})
+		s.outdoor.known, s.outdoor.current = true, tc.outdoor
+		s.overrides = newOverrides("")
+		s.audit = newAudit(config.Audit{File: filepath.Join(t.TempDir(), "audit.jsonl")})
+		if err := s.cs.InitWithConfig(control.Config{URL: relay.URL}, false); err != nil {
+			t.Fatalf("%s: failed to initialize relay: %s", k, err.Error())
+		}
+		if d := s.control(context.Background()); d.mode != control.Normal {
+			t.Fatalf("%s: unexpected decision: %+v", k, d)
+		}
+		resp, err := http.Get(relay.URL + "?turn=on&source=" + tc.source)
+		if err != nil {
+			t.Fatalf("%s: failed to turn relay on: %s", k, err.Error())
+		}
+		resp.Body.Close()
+
+		manual := s.reconcile(context.Background(), now)
+		status, err := s.cs.Status(context.Background())
+		relay.Close()
+		if err != nil || status.Mode != tc.expectedMode || manual != !tc.expectedUntil.IsZero() {
+			t.Errorf("%s: reconcile()\ngot:  %s (manual: %v, %v)\nwant: %s\n", k, status.Mode, manual, err, tc.expectedMode)
+			continue
+		}
+		if tc.expectedUntil.IsZero() {
+			continue
+		}
+		// decision of the next control cycle keeps the manual change until the override ends
+		d := s.decide(now)
+		if d.mode != control.Lowered || !d.until.Equal(tc.expectedUntil) || !strings.HasPrefix(d.reason, "manual change on the relay ("+tc.source+")") {
+			t.Errorf("%s: unexpected decision after manual change: %+v", k, d)
+		}
+		if o, ok := s.overrides.Active(tc.expectedUntil); ok {
+			t.Errorf("%s: manual change did not end at %s: %+v", k, tc.expectedUntil, o)
+		}
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "thermia.yaml")
+	priceFile := filepath.Join(dir, "prices.csv")
+	prices := "time,price\n"
+	for hour := 0; hour < 24; hour++ {
+		prices += fmt.Sprintf("2022-12-01T%02d:00,%d\n", hour, hour*10) // 0-23 c/kWh
+	}
+	if err := os.WriteFile(priceFile, []byte(prices), 0600); err != nil {
+		t.Fatalf("failed to write price file: %s", err.Error())
+	}
+	if err := os.WriteFile(configFile, []byte("provider: {token: test}\nrelays: {url: \"http://127.0.0.1/relay/0\"}\nstrategy: {threshold: 10}\n"), 0600); err != nil {
+		t.Fatalf("failed to write configuration file: %s", err.Error())
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		t.Fatalf("config.Load() did not succeed: %s", err.Error())
+	}
+
+	loc, _ := time.LoadLocation(cfg.Timezone)
+	s, err := newSimulationState(cfg, map[string][]float64{})
+	if err != nil {
+		t.Fatalf("newSimulationState() did not succeed: %s", err.Error())
+	}
+	s.sp.HourPrice, err = loadSimulationPrices(cfg, priceFile, loc)
+	if err != nil {
+		t.Fatalf("loadSimulationPrices() did not succeed: %s", err.Error())
+	}
+	night, _ := schedule.Parse([]string{defaultNightWindow})
+	from := time.Date(2022, 12, 1, 0, 0, 0, 0, loc)
+	days := s.simulate(from, from.AddDate(0, 0, 2), night)
+	expected := []simulationDay{
+		{day: from, heatingHours: 11, loweredHours: 13, heatingSum: 55, allHours: 24, allSum: 276, nightHours: 9, nightSum: 66},
+		{day: from.AddDate(0, 0, 1), missingHours: 24},
+	}
+	if !reflect.DeepEqual(days, expected) {
+		t.Fatalf("simulate()\ngot:  %+v\nwant: %+v", days, expected)
+	}
+
+	var b strings.Builder
+	tr := tariff{nightWindow: defaultNightWindow, dayPrice: 12, nightPrice: 8}
+	if err = runSimulation(&b, cfg, "2022-12-01..2022-12-02", priceFile, 2, tr); err != nil {
+		t.Fatalf("runSimulation() did not succeed: %s", err.Error())
+	}
+	for _, e := range []string{"2022-12-01  11  13  0  5.00  1.10", "Total  11  13  24", "Strategy  11  5.00  1.10  -",
+		"Always on  24  11.50  5.52  56.5 %", "Night at spot (22:00-07:00)  9  7.33  1.32  31.8 %",
+		"Night tariff (22:00-07:00)  9  8.00  1.44  37.5 %", "Day/night tariff  24  10.50  5.04  52.4 %"} {
+		if !strings.Contains(strings.Join(strings.Fields(b.String()), " "), strings.Join(strings.Fields(e), " ")) {
+			t.Errorf("simulation does not contain %q:\n%s", e, b.String())
+		}
+	}
+
+	// tariff baselines are shown only when the tariff is given
+	b.Reset()
+	if err = runSimulation(&b, cfg, "2022-12-01", priceFile, 2, tariff{nightWindow: defaultNightWindow}); err != nil {
+		t.Fatalf("runSimulation() did not succeed: %s", err.Error())
+	}
+	if strings.Contains(b.String(), "tariff") {
+		t.Errorf("simulation without tariff prices contains tariff baselines:\n%s", b.String())
+	}
+
+	for name, tr := range map[string]tariff{
+		"invalid night window": {nightWindow: "tonight"},
+		"only day price":       {nightWindow: defaultNightWindow, dayPrice: 12},
+		"negative price":       {nightWindow: defaultNightWindow, dayPrice: 12, nightPrice: -1},
+	} {
+		if err = runSimulation(&b, cfg, "2022-12-01", priceFile, 2, tr); err == nil {
+			t.Errorf("runSimulation() should fail with %s", name)
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	loc, _ := time.LoadLocation("Europe/Helsinki")
+
+	// ENTSO-E returns hourly prices of the requested period, failing requests while unavailable is set
+	var requests []string
+	unavailable := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := time.Parse("200601021504", r.URL.Query().Get("periodStart"))
+		end, _ := time.Parse("200601021504", r.URL.Query().Get("periodEnd"))
+		requests = append(requests, start.In(loc).Format("2006-01-02")+".."+end.In(loc).Format("2006-01-02"))
+		if unavailable && end.After(time.Date(2022, 12, 4, 0, 0, 0, 0, loc)) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var points strings.Builder
+		for position := 1; position <= int(end.Sub(start).Hours()); position++ {
+			fmt.Fprintf(&points, "<Point><position>%d</position><price.amount>%d</price.amount></Point>", position, position)
+		}
+		fmt.Fprintf(w, `<Publication_MarketDocument><TimeSeries><Period><timeInterval><start>%s</start><end>%s</end></timeInterval>
+<resolution>PT60M</resolution>%s</Period></TimeSeries></Publication_MarketDocument>`, start.Format("2006-01-02T15:04Z"), end.Format("2006-01-02T15:04Z"), points.String())
+	}))
+	defer server.Close()
+
+	var sp spotprice.State
+	if err := sp.InitWithConfig(spotprice.Config{Token: "token", URL: server.URL}); err != nil {
+		t.Fatalf("InitWithConfig() did not succeed: %s", err.Error())
+	}
+	store, err := pricestore.Open(filepath.Join(t.TempDir(), "prices.json"))
+	if err != nil {
+		t.Fatalf("pricestore.Open() did not succeed: %s", err.Error())
+	}
+	store.Set("20221203", make([]float64, 24))
+
+	from := time.Date(2022, 12, 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 0, 5)
+	if err = backfill(context.Background(), io.Discard, store, sp, from, to, 2, 0); err == nil {
+		t.Fatalf("backfill() should fail when prices are not available")
+	}
+	if expected := []string{"2022-12-01..2022-12-03", "2022-12-04..2022-12-06"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("requests\ngot:  %v\nwant: %v", requests, expected)
+	}
+	if days := store.Days(); !reflect.DeepEqual(days, []string{"20221201", "20221202", "20221203"}) {
+		t.Fatalf("unexpected days after failed backfill: %v", days)
+	}
+	if prices := store.Day("20221202"); len(prices) != 24 || prices[0] != 25 {
+		t.Errorf("unexpected prices: %v", prices)
+	}
+
+	// backfill continues from the missing days
+	requests = nil
+	unavailable = false
+	if err = backfill(context.Background(), io.Discard, store, sp, from, to, 2, 0); err != nil {
+		t.Fatalf("backfill() did not succeed: %s", err.Error())
+	}
+	if expected := []string{"2022-12-04..2022-12-06"}; !reflect.DeepEqual(requests, expected) {
+		t.Errorf("requests\ngot:  %v\nwant: %v", requests, expected)
+	}
+	if days := store.Days(); len(days) != 5 {
+		t.Errorf("unexpected days: %v", days)
+	}
+}
+
+func TestPlanPreview(t *testing.T) {
+	prices := make([]float64, 24)
+	for hour := range prices {
+		prices[hour] = float64(hour * 10) // c/kWh = hour
+	}
+	s := newTestState(prices)
+	s.threshold = 5.5
+	now := testNow
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	s.sp.HourPrice[midnight.AddDate(0, 0, 1).Format(spotprice.DateLayout)] = prices
+
+	rows := planRows(s.plan(midnight, 48))
+	if len(rows) != 48 {
+		t.Fatalf("expected 48 hours, got %d", len(rows))
+	}
+	for i, expected := range map[int]int{0: 1, 5: 6, 23: 6, 24: 1, 47: 6} {
+		if rows[i].ActiveHours != expected {
+			t.Errorf("hour %d: expected %d active hours, got %d", i, expected, rows[i].ActiveHours)
+		}
+	}
+
+	var b strings.Builder
+	printPlan(&b, rows[5:7])
+	for _, e := range []string{"5.00 NORMAL 6", "6.00 LOWERED 6", "price higher than the threshold: 6.00 (threshold: 5.50)"} {
+		if !strings.Contains(strings.Join(strings.Fields(b.String()), " "), e) {
+			t.Errorf("plan does not contain %q:\n%s", e, b.String())
+		}
+	}
+
+	data, err := json.Marshal(rows[0])
+	if err != nil {
+		t.Fatalf("failed to marshal plan: %s", err.Error())
+	}
+	var row map[string]interface{}
+	if err = json.Unmarshal(data, &row); err != nil || row["activeHours"] != 1.0 || row["mode"] == nil || row["reason"] == nil {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	relay := newTestRelay()
+	defer relay.Close()
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "thermia.yaml")
+	overrideFile := filepath.Join(dir, "override.json")
+	content := fmt.Sprintf("provider: {token: test}\nrelays: {url: %q}\noverride: {file: %q}\n", relay.URL, overrideFile)
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write configuration file: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		args []string
+		fail bool
+	}{
+		"Validate configuration":      {args: []string{"validate-config", "-config", configFile}},
+		"Invalid configuration":       {args: []string{"validate-config", "-config", filepath.Join(dir, "missing.yaml")}, fail: true},
+		"Version":                     {args: []string{"version"}},
+		"Unknown command":             {args: []string{"start"}, fail: true},
+		"Flags after argument":        {args: []string{"set", "on", "-for", "1h", "-config", configFile}},
+		"Missing argument":            {args: []string{"set", "-config", configFile}, fail: true},
+		"Too many arguments":          {args: []string{"audit", "2022-12-01", "2022-12-02", "-config", configFile}, fail: true},
+		"Unknown mode":                {args: []string{"set", "-config", configFile, "warm"}, fail: true},
+		"EVU STOP without EVU relay":  {args: []string{"set", "-config", configFile, "evustop"}, fail: true},
+		"Audit with invalid range":    {args: []string{"audit", "-config", configFile, "yesterday"}, fail: true},
+		"Simulation with invalid day": {args: []string{"simulate", "-config", configFile, "2022-13-01"}, fail: true},
+	}
+	for k, c := range cases {
+		if err := execute(context.Background(), c.args); (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error: %v", k, err)
+		}
+	}
+
+	// relay on is an override to ROOM LOWERING mode and the relay is set without a running controller
+	o, ok := newOverrides(overrideFile).Active(time.Now())
+	if !ok || o.Mode != control.Lowered || o.Until.After(time.Now().Add(time.Hour)) {
+		t.Errorf("unexpected override: %+v (active: %v)", o, ok)
+	}
+	var cs control.State
+	cs.InitWithConfig(control.Config{URL: relay.URL}, false)
+	if status, err := cs.Status(context.Background()); err != nil || status.Mode != control.Lowered {
+		t.Errorf("set did not set the relay: %+v (%v)", status, err)
+	}
+
+	// frost protection applies to the relays set without a running controller
+	indoor := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"temperature": 3.5}`)
+	}))
+	defer indoor.Close()
+	content += fmt.Sprintf("indoor: {source: http, url: %q}\n", indoor.URL)
+	if err := os.WriteFile(configFile, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write configuration file: %s", err.Error())
+	}
+	if err := execute(context.Background(), []string{"set", "lowered", "-config", configFile}); err != nil {
+		t.Fatalf("set failed: %s", err.Error())
+	}
+	if status, err := cs.Status(context.Background()); err != nil || status.Mode != control.Normal {
+		t.Errorf("set did not apply frost protection: %+v (%v)", status, err)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"time"
+
+	"github.com/koovee/thermia/control"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	priceGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "thermia_price",
+		Help: "Current total electricity price (c/kWh)",
+	})
+	thresholdGauge = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "thermia_threshold",
+		Help: "Configured price threshold (c/kWh), 0 when not used",
+	})
+	modeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thermia_mode",
+		Help: "Current operating mode (1 for the current mode)",
+	}, []string{"mode"})
+	plannedModeGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thermia_planned_mode",
+		Help: "Planned operating mode for the next hour (1 for the planned mode)",
+	}, []string{"mode"})
+	relayGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thermia_relay_on",
+		Help: "Relay state set by the controller (1 = on)",
+	}, []string{"relay"})
+	temperatureGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thermia_temperature_celsius",
+		Help: "Latest known temperature",
+	}, []string{"location"})
+	fallbackCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "thermia_fallback_activations_total",
+		Help: "Number of decisions made with the fallback schedule because the strategy failed",
+	})
+	controlCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "thermia_control_cycles_total",
+		Help: "Number of control cycles by result",
+	}, []string{"result"})
+	driftCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "thermia_relay_drift_total",
+		Help: "Number of times relays were found changed outside the controller",
+	})
+)
+
+var modes = []control.Mode{control.Normal, control.Lowered, control.EVUStop}
+
+// updateMetrics updates metrics after a control cycle. Relay states are updated only when relays were set successfully.
+func (s state) updateMetrics(d decision, now time.Time, applyErr error) {
+	if price, err := s.sp.GetPrice(now); err == nil {
+		priceGauge.Set(price)
+	}
+	thresholdGauge.Set(s.threshold)
+	setMode(modeGauge, d.mode)
+	if plan := s.plan(now.Truncate(time.Hour).Add(time.Hour), 1); len(plan) > 0 {
+		setMode(plannedModeGauge, plan[0].Mode)
+	}
+	if s.outdoor.known {
+		temperatureGauge.WithLabelValues("outdoor").Set(s.outdoor.current)
+	}
+	if s.indoor.known {
+		temperatureGauge.WithLabelValues("indoor").Set(s.indoor.temperature)
+	}
+
+	if applyErr != nil {
+		controlCounter.WithLabelValues("error").Inc()
+		return
+	}
+	controlCounter.WithLabelValues("ok").Inc()
+	relayGauge.WithLabelValues("relay").Set(boolValue(d.mode == control.Lowered))
+	if s.cfg.Relays.BoostURL != "" {
+		relayGauge.WithLabelValues("boost").Set(boolValue(d.boost))
+	}
+	if s.cfg.Relays.EVUURL != "" {
+		relayGauge.WithLabelValues("evu").Set(boolValue(d.mode == control.EVUStop))
+	}
+}
+
+// setMode sets 1 for the given mode and 0 for the other modes
+func setMode(g *prometheus.GaugeVec, mode control.Mode) {
+	for _, m := range modes {
+		g.WithLabelValues(m.String()).Set(boolValue(m == mode))
+	}
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/temperature"
+)
+
+// outdoor keeps track of outdoor temperature and maps it (current and forecast) to the number of active hours when
+// heating curve is set
+type outdoor struct {
+	source        temperature.Source
+	curve         temperature.Curve
+	forecastHours int
+	current       float64 // last known outdoor temperature
+	known         bool
+}
+
+// outdoorMaxAge is the age of the latest outdoor temperature after which it is not used (FMI observations are
+// published with a delay)
+const outdoorMaxAge = 3 * time.Hour
+
+// newOutdoor returns outdoor temperature source and heating curve from a validated configuration
+func newOutdoor(c config.Outdoor, clk clock.Clock) (o outdoor, err error) {
+	switch c.Source {
+	case config.SourceHTTP:
+		o.source = temperature.NewHTTPSource(c.URL, outdoorMaxAge, clk)
+	case config.SourceFMI:
+		o.source = temperature.NewFMISource(c.URL, c.Place, outdoorMaxAge, clk)
+	}
+
+	if c.HeatingCurve != "" {
+		o.curve, err = temperature.ParseCurve(c.HeatingCurve)
+		if err != nil {
+			log.Error("failed to parse heating curve", "error", err)
+			return
+		}
+	}
+	o.forecastHours = c.ForecastHours
+	return
+}
+
+// updateOutdoorTemperature reads outdoor temperature and sets activeHours based on heating curve. The previous
+// activeHours is kept when outdoor temperature is not available.
+func (s *state) updateOutdoorTemperature(ctx context.Context) {
+	if s.outdoor.source == nil {
+		return
+	}
+
+	current, err := s.outdoor.source.Current(ctx)
+	if err != nil {
+		log.Warn("failed to get outdoor temperature", "error", err)
+		s.outdoor.known = false
+		return
+	}
+	s.outdoor.current = current
+	s.outdoor.known = true
+	log.Info("outdoor temperature", "temperature", current)
+
+	if s.outdoor.curve == nil {
+		return
+	}
+
+	t, err := temperature.Effective(ctx, s.outdoor.source, s.outdoor.forecastHours)
+	if err != nil {
+		log.Warn("failed to get effective outdoor temperature, keeping active hours", "activeHours", s.activeHours, "error", err)
+		return
+	}
+
+	activeHours := s.outdoor.curve.ActiveHours(t)
+	if activeHours != s.activeHours {
+		log.Info("active hours changed", "temperature", t, "from", s.activeHours, "to", activeHours)
+	}
+	s.activeHours = activeHours
+}
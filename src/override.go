@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/override"
+)
+
+const overrideWatchInterval = 5 * time.Second
+
+// newOverrides returns the override store. Problems reading the override file are logged and the controller starts
+// without an override.
+func newOverrides(path string) *override.Store {
+	store, err := override.NewStore(path)
+	if err != nil {
+		log.Warn("failed to load override, ignoring it", "error", err)
+	}
+	return store
+}
+
+// setOverride sets (or clears with mode "clear") manual override from command line, prints the result and returns
+// the override. EVU STOP requires EVU relay.
+func setOverride(store *override.Store, mode string, boost bool, now time.Time, duration time.Duration, evuRelay bool) (o override.Override, err error) {
+	if mode == "clear" {
+		if err = store.Clear(); err != nil {
+			return o, err
+		}
+		fmt.Printf("override cleared, a running controller returns to automatic control\n")
+		return o, nil
+	}
+
+	m, err := control.ParseMode(mode)
+	if err != nil {
+		return o, err
+	}
+	if m == control.EVUStop && !evuRelay {
+		return o, errors.New("EVU STOP override requires EVU relay (EVU_SHELLY_URL)")
+	}
+	o, err = store.Set(m, boost, now, duration)
+	if err != nil {
+		return o, err
+	}
+	fmt.Printf("override set: %s (boost: %v) until %s\n", o.Mode, o.Boost, o.Until.Format(time.RFC822))
+	return o, nil
+}
+
+// watchOverride sends to changed channel when the override file changes (e.g. override set from command line)
+func watchOverride(path string, changed chan<- struct{}) {
+	w := newFileWatcher(path)
+	ticker := time.NewTicker(overrideWatchInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if w.changed() {
+			changed <- struct{}{}
+		}
+	}
+}
+
+// applyOverride returns the manual override decision if an override is active. Override is consulted before any
+// strategy and only frost protection is applied on top of it.
+func (s state) applyOverride(now time.Time) (d decision, ok bool) {
+	if s.overrides == nil {
+		return d, false
+	}
+	o, ok := s.overrides.Active(now)
+	if !ok {
+		return d, false
+	}
+	reason := fmt.Sprintf("manual override until %s", o.Until.Format(time.RFC822))
+	if o.Source != "" {
+		reason = fmt.Sprintf("manual change on the relay (%s) until %s", o.Source, o.Until.Format(time.RFC822))
+	}
+	return decision{
+		mode:   o.Mode,
+		boost:  o.Boost,
+		reason: reason,
+		until:  o.Until,
+	}, true
+}
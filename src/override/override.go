@@ -0,0 +1,150 @@
+package override
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/koovee/thermia/control"
+)
+
+// Override forces an operating mode until it expires
+type Override struct {
+	Mode    control.Mode `json:"mode"`
+	Boost   bool         `json:"boost"`
+	Until   time.Time    `json:"until"`
+	Created time.Time    `json:"created"`
+	Source  string       `json:"source,omitempty"` // relay change source for manual changes made on the relay
+}
+
+// Store keeps the current override. Override is persisted to a file (if path is set) so that it survives restarts.
+type Store struct {
+	path       string
+	m          sync.Mutex
+	current    *Override
+	boostRelay bool
+}
+
+// NewStore returns a store that persists the override to path (empty path keeps the override in memory only). The
+// current override is loaded from the file.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path}
+	return s, s.Reload()
+}
+
+// SetBoostRelay tells whether the boost relay is available. Boost overrides are rejected without it.
+func (s *Store) SetBoostRelay(available bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.boostRelay = available
+}
+
+// Path returns the path of the override file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Reload loads the override from the file (e.g. after it has been changed by another process)
+func (s *Store) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.m.Lock()
+		s.current = nil
+		s.m.Unlock()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read override file: %w", err)
+	}
+
+	var o *Override
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &o); err != nil {
+			return fmt.Errorf("failed to parse override file (%s): %w", s.path, err)
+		}
+	}
+	s.m.Lock()
+	s.current = o
+	s.m.Unlock()
+	return nil
+}
+
+// Set sets an override for the given duration
+func (s *Store) Set(mode control.Mode, boost bool, now time.Time, duration time.Duration) (Override, error) {
+	if duration <= 0 {
+		return Override{}, fmt.Errorf("override duration must be positive (%s)", duration)
+	}
+	if boost && mode != control.Normal {
+		return Override{}, fmt.Errorf("boost is only allowed with %s mode", control.Normal)
+	}
+	s.m.Lock()
+	boostRelay := s.boostRelay
+	s.m.Unlock()
+	if boost && !boostRelay {
+		return Override{}, errors.New("boost requires boost relay (BOOST_SHELLY_URL)")
+	}
+	o := Override{Mode: mode, Boost: boost, Until: now.Add(duration), Created: now}
+	return o, s.save(&o)
+}
+
+// SetManual sets an override for a manual change made on the relay itself (e.g. button or Shelly app) until the given
+// time. Source is the change source reported by the relay.
+func (s *Store) SetManual(mode control.Mode, boost bool, source string, now, until time.Time) (Override, error) {
+	if !until.After(now) {
+		return Override{}, fmt.Errorf("override must end in the future (%s)", until)
+	}
+	o := Override{Mode: mode, Boost: boost && mode == control.Normal, Until: until, Created: now, Source: source}
+	return o, s.save(&o)
+}
+
+// Clear removes the override
+func (s *Store) Clear() error {
+	return s.save(nil)
+}
+
+// Active returns the override if it has not expired
+func (s *Store) Active(now time.Time) (o Override, ok bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.current == nil || !now.Before(s.current.Until) {
+		return o, false
+	}
+	return *s.current, true
+}
+
+func (s *Store) save(o *Override) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.path != "" {
+		data, err := json.MarshalIndent(o, "", "  ")
+		if err != nil {
+			return err
+		}
+		// write to a temporary file first so that the override file is never partially written
+		tmp, err := os.CreateTemp(filepath.Dir(s.path), ".override-*")
+		if err != nil {
+			return fmt.Errorf("failed to write override file: %w", err)
+		}
+		defer os.Remove(tmp.Name())
+		if _, err = tmp.Write(data); err != nil {
+			tmp.Close()
+			return fmt.Errorf("failed to write override file: %w", err)
+		}
+		if err = tmp.Close(); err != nil {
+			return fmt.Errorf("failed to write override file: %w", err)
+		}
+		if err = os.Rename(tmp.Name(), s.path); err != nil {
+			return fmt.Errorf("failed to write override file: %w", err)
+		}
+	}
+	s.current = o
+	return nil
+}
@@ -0,0 +1,96 @@
+package override
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/koovee/thermia/control"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "override.json")
+	now := time.Date(2022, 10, 28, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() did not succeed: %s", err.Error())
+	}
+	if _, ok := s.Active(now); ok {
+		t.Fatalf("new store should not have an active override")
+	}
+
+	if _, err = s.Set(control.Lowered, true, now, time.Hour); err == nil {
+		t.Fatalf("Set() with boost in LOWERED mode should have failed, but it succeeded")
+	}
+	if _, err = s.Set(control.EVUStop, false, now, 0); err == nil {
+		t.Fatalf("Set() without duration should have failed, but it succeeded")
+	}
+	if _, err = s.Set(control.EVUStop, false, now, 3*time.Hour); err != nil {
+		t.Fatalf("Set() did not succeed: %s", err.Error())
+	}
+
+	// override survives restart
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() did not succeed: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		time           time.Time
+		expectedActive bool
+	}{
+		"Active":  {time: now.Add(2 * time.Hour), expectedActive: true},
+		"Expired": {time: now.Add(3 * time.Hour), expectedActive: false},
+	}
+	for k, tc := range cases {
+		o, ok := s.Active(tc.time)
+		if ok != tc.expectedActive || (ok && o.Mode != control.EVUStop) {
+			t.Fatalf("%s: Active\ngot:  %v (%s)\nwant: %v\n", k, ok, o.Mode, tc.expectedActive)
+		}
+	}
+
+	if err = s.Clear(); err != nil {
+		t.Fatalf("Clear() did not succeed: %s", err.Error())
+	}
+	if err = s.Reload(); err != nil {
+		t.Fatalf("Reload() did not succeed: %s", err.Error())
+	}
+	if _, ok := s.Active(now); ok {
+		t.Fatalf("cleared override should not be active")
+	}
+}
+
+func TestSetManual(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "override.json")
+	now := time.Date(2022, 10, 28, 12, 30, 0, 0, time.UTC)
+
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() did not succeed: %s", err.Error())
+	}
+	if _, err = s.SetManual(control.Lowered, false, "input", now, now); err == nil {
+		t.Fatalf("SetManual() ending now should have failed, but it succeeded")
+	}
+	if _, err = s.SetManual(control.Lowered, true, "input", now, now.Add(30*time.Minute)); err != nil {
+		t.Fatalf("SetManual() did not succeed: %s", err.Error())
+	}
+
+	// source survives restart, boost is ignored in LOWERED mode
+	s, err = NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore() did not succeed: %s", err.Error())
+	}
+	o, ok := s.Active(now.Add(29 * time.Minute))
+	if !ok || o.Mode != control.Lowered || o.Boost || o.Source != "input" {
+		t.Fatalf("Active() returned unexpected override: %+v (%v)", o, ok)
+	}
+	if _, ok = s.Active(now.Add(30 * time.Minute)); ok {
+		t.Fatalf("manual override should have expired")
+	}
+}
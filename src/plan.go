@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+)
+
+// planHour is the planned operating mode for an hour
+type planHour struct {
+	Time     time.Time    `json:"time"`
+	Price    float64      `json:"price"`
+	Mode     control.Mode `json:"mode"`
+	Boost    bool         `json:"boost"`
+	Strategy string       `json:"strategy"`
+	Profile  string       `json:"profile,omitempty"`
+	Reason   string       `json:"reason"`
+}
+
+// plan returns planned operating modes from the current hour for up to n hours (as long as prices are known). Plan
+// uses the latest temperatures, so it may change when temperatures change.
+func (s state) plan(now time.Time, n int) (plan []planHour) {
+	s.quiet = true
+	prices := s.sp.PricesFrom(now, n)
+	hour := now.Truncate(time.Hour)
+	for i, price := range prices {
+		t := hour.Add(time.Duration(i) * time.Hour)
+		if i == 0 {
+			t = now
+		}
+		d := s.decide(t)
+		plan = append(plan, planHour{
+			Time:     t.Truncate(time.Hour),
+			Price:    price,
+			Mode:     d.mode,
+			Boost:    d.boost,
+			Strategy: d.strategy,
+			Profile:  d.profile,
+			Reason:   d.reason,
+		})
+	}
+	return plan
+}
+
+// planRow is a planned hour with the number of heating hours of the day up to and including the hour
+type planRow struct {
+	planHour
+	ActiveHours int `json:"activeHours"`
+}
+
+// runPlan prints the plan for the remaining hours of today and tomorrow. Prices are read from the price store and
+// fetched from the provider when missing. Relays are not touched.
+func runPlan(ctx context.Context, w io.Writer, cfg config.Config, jsonOutput bool) error {
+	s, err := newState(cfg, true, clock.Real, nil)
+	if err != nil {
+		return err
+	}
+	s.sp.UpdateSpotPrices(ctx)
+	s.storePrices()
+	s.updateOutdoorTemperature(ctx)
+	s.updateIndoorTemperature(ctx)
+
+	now := s.clock.Now()
+	end := time.Date(now.Year(), now.Month(), now.Day()+2, 0, 0, 0, 0, now.Location())
+	rows := planRows(s.plan(now, int(end.Sub(now.Truncate(time.Hour)).Hours())))
+	if jsonOutput {
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(rows)
+	}
+	if len(rows) == 0 {
+		fmt.Fprintf(w, "no prices available\n")
+		return nil
+	}
+	printPlan(w, rows)
+	return nil
+}
+
+// planRows counts heating hours of each day of the plan
+func planRows(plan []planHour) (rows []planRow) {
+	active := 0
+	for i, h := range plan {
+		if i > 0 && h.Time.Day() != plan[i-1].Time.Day() {
+			active = 0
+		}
+		if h.Mode == control.Normal {
+			active++
+		}
+		rows = append(rows, planRow{planHour: h, ActiveHours: active})
+	}
+	return rows
+}
+
+// printPlan prints the plan as a table
+func printPlan(w io.Writer, rows []planRow) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Time\tPrice (c/kWh)\tMode\tActive (h)\tStrategy\tReason\n")
+	for _, r := range rows {
+		mode := r.Mode.String()
+		if r.Boost {
+			mode += " + BOOST"
+		}
+		strategy := r.Strategy
+		if r.Profile != "" {
+			strategy += fmt.Sprintf(" (%s profile)", r.Profile)
+		}
+		fmt.Fprintf(tw, "%s\t%.2f\t%s\t%d\t%s\t%s\n", r.Time.Format("2006-01-02 15:04"), r.Price, mode, r.ActiveHours, strategy, r.Reason)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/koovee/thermia/building"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+)
+
+const (
+	defaultIndoorSetpoint = 21.0 // indoor temperature used when it is not known (°C)
+	defaultOutdoor        = 0.0  // outdoor temperature used when it is not known (°C)
+)
+
+// preheat heats in the hours immediately preceding a price spike so that the house can coast through it. Building
+// model tells how many hours of heating the spike needs.
+type preheat struct {
+	enabled bool
+	model   building.Model
+	drop    float64 // allowed indoor temperature change while coasting and pre-heating (°C)
+	hours   int     // maximum number of hours to pre-heat before a price spike
+	spread  float64 // minimum price difference between pre-heat hour and spike (c/kWh)
+}
+
+// newPreheat returns pre-heating configuration. Pre-heating is enabled when building model is configured.
+func newPreheat(b config.Building, c config.Preheat) (p preheat) {
+	p.model = building.Model{HeatLoss: b.HeatLoss, Capacity: b.Capacity, Power: b.HeatingPower}
+	p.enabled = p.model.Validate() == nil
+	p.drop, p.hours, p.spread = c.Drop, c.Hours, c.Spread
+	return
+}
+
+// temperatures returns the last known indoor and outdoor temperatures (or defaults)
+func (s state) temperatures() (indoor, outdoor float64) {
+	indoor, outdoor = defaultIndoorSetpoint, defaultOutdoor
+	if s.indoor.known {
+		indoor = s.indoor.temperature
+	}
+	if s.outdoor.known {
+		outdoor = s.outdoor.current
+	}
+	return indoor, outdoor
+}
+
+// preheatHours returns how many hours of heating the house needs before a price spike of length hours starting in
+// start hours, and the indoor temperature aimed at. No pre-heating is needed when the house can coast (with heating
+// lowered) until the end of the spike without indoor temperature dropping more than drop. Otherwise the house is
+// heated to the temperature it needs at the start of the spike to coast through it (at most drop above the current
+// temperature). Result is limited to the maximum pre-heat hours.
+func (s state) preheatHours(start, length int) (hours int, target float64) {
+	indoor, outdoor := s.temperatures()
+	m := s.preheat.model
+	if m.Temperature(indoor, outdoor, float64(start+length)) >= indoor-s.preheat.drop {
+		return 0, indoor
+	}
+	target = math.Min(m.StartTemperature(indoor-s.preheat.drop, outdoor, float64(length)), indoor+s.preheat.drop)
+	heating := m.HeatingHours(indoor, outdoor, target)
+	if heating >= float64(s.preheat.hours) {
+		return s.preheat.hours, target
+	}
+	return int(math.Max(1, math.Ceil(heating))), target
+}
+
+// applyPreheat turns heating ON when a price spike (price higher than the threshold and at least spread higher than
+// the current price) starts within the hours of heating the house needs before it, and the house can coast through
+// at least one hour of the spike.
+func (s state) applyPreheat(now time.Time, price float64, d decision) decision {
+	if !s.preheat.enabled || d.mode == control.Normal {
+		return d
+	}
+
+	// prices[0] is the current hour
+	prices := s.sp.PricesFrom(now, s.preheat.hours+24)
+	for start := 1; start <= s.preheat.hours && start < len(prices); start++ {
+		if prices[start] <= s.threshold || prices[start]-price < s.preheat.spread {
+			continue
+		}
+
+		length := 0
+		for _, p := range prices[start:] {
+			if p <= s.threshold {
+				break
+			}
+			length++
+		}
+
+		indoor, outdoor := s.temperatures()
+		if coastHours := s.preheat.model.CoastHours(indoor, outdoor, s.preheat.drop); coastHours < 1 {
+			s.logger().Debug("price spike ahead, but house cannot coast long enough", "hours", start, "coastHours", coastHours)
+			return d
+		}
+		hours, target := s.preheatHours(start, length)
+		if start > hours {
+			s.logger().Debug("price spike ahead, pre-heating not needed yet", "hours", start, "length", length, "preheatHours", hours)
+			return d
+		}
+		return decision{
+			mode:   control.Normal,
+			boost:  d.boost,
+			reason: fmt.Sprintf("pre-heating %d hours before %d hour price spike starting in %d hours (price: %0.2f, spike: %0.2f, indoor target: %0.1f)", hours, length, start, price, prices[start], target),
+		}
+	}
+	return d
+}
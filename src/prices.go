@@ -0,0 +1,58 @@
+package main
+
+import (
+	"time"
+
+	"github.com/koovee/thermia/pricestore"
+	"github.com/koovee/thermia/spotprice"
+)
+
+// newPriceStore returns the price store (nil when the store is disabled). Problems reading the store are logged and
+// the controller starts with an empty store.
+func newPriceStore(path string) *pricestore.Store {
+	if path == "" {
+		return nil
+	}
+	store, err := pricestore.Open(path)
+	if err != nil {
+		log.Warn("failed to load price store, starting with an empty store", "error", err)
+	}
+	return store
+}
+
+// loadPrices copies today's and tomorrow's prices from the price store, so that prices are not fetched again after
+// restart
+func (s *state) loadPrices(now time.Time) {
+	if s.prices == nil {
+		return
+	}
+	s.sp.M.Lock()
+	defer s.sp.M.Unlock()
+	for _, day := range []time.Time{now, now.AddDate(0, 0, 1)} {
+		key := day.Format(spotprice.DateLayout)
+		if prices := s.prices.Day(key); len(prices) > 0 {
+			s.sp.HourPrice[key] = prices
+		}
+	}
+}
+
+// storePrices saves fetched prices to the price store
+func (s state) storePrices() {
+	if s.prices == nil {
+		return
+	}
+	changed := false
+	s.sp.M.Lock()
+	for day, prices := range s.sp.HourPrice {
+		if len(prices) > 0 && s.prices.Set(day, prices) {
+			changed = true
+		}
+	}
+	s.sp.M.Unlock()
+	if !changed {
+		return
+	}
+	if err := s.prices.Save(); err != nil {
+		log.Error("failed to save prices to price store", "error", err)
+	}
+}
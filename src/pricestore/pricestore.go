@@ -0,0 +1,213 @@
+package pricestore
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DateLayout is the layout of day keys (same as in spotprice)
+	DateLayout = "20060102"
+
+	csvTimeLayout = "2006-01-02T15:04"
+)
+
+// Store keeps hourly spot prices (EUR/MWh) by day in a JSON file, so that prices survive restarts and can be used for
+// simulation and reporting
+type Store struct {
+	path string
+	m    sync.Mutex
+	days map[string][]float64
+}
+
+// Open loads the store from path. Missing file is an empty store.
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, days: make(map[string][]float64)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return s, fmt.Errorf("failed to read price store: %w", err)
+	}
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &s.days); err != nil {
+			return s, fmt.Errorf("failed to parse price store (%s): %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Path returns the path of the store file
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Day returns the prices of a day (nil if the day is not stored)
+func (s *Store) Day(day string) []float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return append([]float64(nil), s.days[day]...)
+}
+
+// Days returns the stored days in order
+func (s *Store) Days() (days []string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for day := range s.days {
+		days = append(days, day)
+	}
+	sort.Strings(days)
+	return days
+}
+
+// Set stores the prices of a day. Returns true if the prices changed (store needs to be saved).
+func (s *Store) Set(day string, prices []float64) bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+	old, ok := s.days[day]
+	if ok && len(old) == len(prices) {
+		same := true
+		for i := range old {
+			if old[i] != prices[i] {
+				same = false
+				break
+			}
+		}
+		if same {
+			return false
+		}
+	}
+	s.days[day] = append([]float64(nil), prices...)
+	return true
+}
+
+// All returns a copy of all stored prices by day
+func (s *Store) All() map[string][]float64 {
+	s.m.Lock()
+	defer s.m.Unlock()
+	days := make(map[string][]float64, len(s.days))
+	for day, prices := range s.days {
+		days[day] = append([]float64(nil), prices...)
+	}
+	return days
+}
+
+// Save writes the store to the file (temporary file and rename, so that the store is never left half written). Days
+// saved to the file by another process (e.g. backfill while the controller runs) are kept.
+func (s *Store) Save() error {
+	saved := make(map[string][]float64)
+	if data, err := os.ReadFile(s.path); err == nil {
+		// unreadable file is replaced
+		_ = json.Unmarshal(data, &saved)
+	}
+
+	s.m.Lock()
+	for day, prices := range saved {
+		if _, ok := s.days[day]; !ok {
+			s.days[day] = prices
+		}
+	}
+	data, err := json.Marshal(s.days)
+	s.m.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal price store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write price store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write price store: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write price store: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write price store: %w", err)
+	}
+	return nil
+}
+
+// ReadCSV reads hourly spot prices from CSV lines "time,price" where time is YYYY-MM-DDTHH:MM (in the given location)
+// or RFC 3339 and price is EUR/MWh. Header line is skipped. Returns prices by day, hours missing from the file are
+// filled with the previous price.
+func ReadCSV(r io.Reader, loc *time.Location) (map[string][]float64, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	days := make(map[string][]float64)
+	line := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		line++
+		if err != nil {
+			return nil, fmt.Errorf("failed to read price file: %w", err)
+		}
+		t, err := parseTime(record[0], loc)
+		if err != nil {
+			if line == 1 {
+				// header
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		price, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid price: %q", line, record[1])
+		}
+
+		SetHour(days, t.In(loc), price)
+	}
+	return days, nil
+}
+
+// SetHour sets the price of the hour of t (local time of t) to prices by day. Hours missing before it are filled with
+// the previous price, so that the index of a price is the hour of the day.
+func SetHour(days map[string][]float64, t time.Time, price float64) {
+	day := t.Format(DateLayout)
+	prices := days[day]
+	for len(prices) < t.Hour() {
+		if len(prices) == 0 {
+			prices = append(prices, price)
+		} else {
+			prices = append(prices, prices[len(prices)-1])
+		}
+	}
+	if len(prices) == t.Hour() {
+		prices = append(prices, price)
+	} else {
+		prices[t.Hour()] = price
+	}
+	days[day] = prices
+}
+
+func parseTime(str string, loc *time.Location) (time.Time, error) {
+	str = strings.TrimSpace(str)
+	if t, err := time.ParseInLocation(csvTimeLayout, str, loc); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return t, fmt.Errorf("invalid time (YYYY-MM-DDTHH:MM or RFC 3339): %q", str)
+	}
+	return t, nil
+}
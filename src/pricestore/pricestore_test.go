@@ -0,0 +1,111 @@
+package pricestore
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() did not succeed: %s", err.Error())
+	}
+	if len(s.Days()) != 0 {
+		t.Fatalf("new store should be empty")
+	}
+
+	prices := []float64{10, 20, 30}
+	if !s.Set("20221202", prices) || !s.Set("20221201", prices) {
+		t.Fatalf("Set() should report new days as changed")
+	}
+	if s.Set("20221201", []float64{10, 20, 30}) {
+		t.Fatalf("Set() should not report unchanged prices as changed")
+	}
+	if !s.Set("20221201", []float64{10, 20, 31}) {
+		t.Fatalf("Set() should report changed prices")
+	}
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save() did not succeed: %s", err.Error())
+	}
+
+	// prices survive restart
+	s, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() did not succeed: %s", err.Error())
+	}
+	if days := s.Days(); !reflect.DeepEqual(days, []string{"20221201", "20221202"}) {
+		t.Errorf("unexpected days: %v", days)
+	}
+	if day := s.Day("20221201"); !reflect.DeepEqual(day, []float64{10, 20, 31}) {
+		t.Errorf("unexpected prices: %v", day)
+	}
+	if day := s.Day("20221203"); day != nil {
+		t.Errorf("missing day should not have prices: %v", day)
+	}
+
+	// days saved by another process are kept
+	other, _ := Open(path)
+	other.Set("20221203", prices)
+	if err = other.Save(); err != nil {
+		t.Fatalf("Save() did not succeed: %s", err.Error())
+	}
+	s.Set("20221201", prices)
+	if err = s.Save(); err != nil {
+		t.Fatalf("Save() did not succeed: %s", err.Error())
+	}
+	s, _ = Open(path)
+	if days := s.Days(); !reflect.DeepEqual(days, []string{"20221201", "20221202", "20221203"}) {
+		t.Errorf("unexpected days after concurrent saves: %v", days)
+	}
+	if day := s.Day("20221201"); !reflect.DeepEqual(day, prices) {
+		t.Errorf("unexpected prices: %v", day)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Fatalf("failed to load location: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		csv      string
+		expected map[string][]float64
+		fail     bool
+	}{
+		"Local times with header": {
+			csv:      "time,price\n2022-12-01T00:00,10.5\n2022-12-01T01:00,-2\n2022-12-02T00:00,30\n",
+			expected: map[string][]float64{"20221201": {10.5, -2}, "20221202": {30}},
+		},
+		"RFC 3339 times": {
+			csv:      "2022-11-30T22:00:00Z,10\n2022-11-30T23:00:00Z,20\n",
+			expected: map[string][]float64{"20221201": {10, 20}},
+		},
+		"Missing hours are filled": {
+			csv:      "# comment\n2022-12-01T00:00,10\n2022-12-01T03:00,40\n",
+			expected: map[string][]float64{"20221201": {10, 10, 10, 40}},
+		},
+		"Invalid time":  {csv: "time,price\nyesterday,10\n", fail: true},
+		"Invalid price": {csv: "2022-12-01T00:00,cheap\n", fail: true},
+		"Missing price": {csv: "2022-12-01T00:00\n", fail: true},
+	}
+	for k, c := range cases {
+		days, err := ReadCSV(strings.NewReader(c.csv), loc)
+		if (err != nil) != c.fail {
+			t.Errorf("%s: unexpected error: %v", k, err)
+			continue
+		}
+		if !c.fail && !reflect.DeepEqual(days, c.expected) {
+			t.Errorf("%s: ReadCSV\ngot:  %v\nwant: %v\n", k, days, c.expected)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/koovee/thermia/calendar"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+)
+
+// profile is a strategy profile used during calendar periods (e.g. away or holiday)
+type profile struct {
+	name     string
+	strategy config.Strategy
+	evuStop  bool // use EVU STOP instead of ROOM LOWERING mode
+}
+
+// newProfiles returns strategy profiles and the calendar of periods when they are used. Periods end returnHours
+// before their end so that the house is back to normal when returning home.
+func newProfiles(cfg config.Config, loc *time.Location) (profiles map[string]profile, cal calendar.Calendar, err error) {
+	profiles = make(map[string]profile)
+	for name, p := range cfg.Profiles {
+		profiles[name] = profile{name: name, strategy: p.Strategy, evuStop: p.EVUStop}
+	}
+
+	for _, p := range cfg.Calendar.Periods {
+		period, err := calendar.ParsePeriod(p.From, p.To, p.Profile, loc)
+		if err != nil {
+			return nil, nil, err
+		}
+		cal = append(cal, period)
+	}
+
+	if cfg.Calendar.File != "" {
+		f, err := os.Open(cfg.Calendar.File)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open calendar file: %w", err)
+		}
+		events, err := calendar.ParseICal(f, loc)
+		f.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse calendar file (%s): %w", cfg.Calendar.File, err)
+		}
+		for _, e := range events {
+			if _, ok := profiles[e.Profile]; !ok {
+				e.Profile = cfg.Calendar.DefaultProfile
+			}
+			cal = append(cal, e)
+		}
+	}
+
+	// return to normal before the period ends
+	periods := cal[:0]
+	for _, p := range cal {
+		p.To = p.To.Add(-time.Duration(cfg.Profiles[p.Profile].ReturnHours) * time.Hour)
+		if p.To.After(p.From) {
+			periods = append(periods, p)
+		}
+	}
+	return profiles, periods, nil
+}
+
+// activeProfile returns the strategy profile for a given time
+func (s state) activeProfile(now time.Time) (p profile, ok bool) {
+	p, ok = s.profiles[s.calendar.Profile(now)]
+	return
+}
+
+// withProfile returns a copy of the state that uses the strategy of the profile
+func (s state) withProfile(p profile) state {
+	s.setStrategy(p.strategy)
+	return s
+}
+
+// applyProfile uses EVU STOP instead of ROOM LOWERING mode if configured for the profile
+func (s state) applyProfile(p profile, d decision) decision {
+	d.reason = fmt.Sprintf("%s profile: %s", p.name, d.reason)
+	if p.evuStop && d.mode == control.Lowered {
+		d.mode = control.EVUStop
+	}
+	return d
+}
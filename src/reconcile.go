@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/control"
+)
+
+// manualSources are the relay change sources of manual changes made on the relay itself: physical switch or button,
+// Shelly app (cloud) and the local web interface
+var manualSources = []string{"input", "button", "cloud", "app", "ws_in"}
+
+// scheduleReconcile restarts the reconciliation timer with the configured interval, or stops it when reconciliation is
+// disabled. It is called after every reconciliation and configuration reload.
+func (s state) scheduleReconcile(t clock.Timer) {
+	if !t.Stop() {
+		// drain the channel, so that a stopped timer does not trigger reconciliation
+		select {
+		case <-t.C():
+		default:
+		}
+	}
+	if s.cfg.Relays.ReconcileInterval > 0 {
+		t.Reset(s.cfg.Relays.ReconcileInterval)
+	}
+}
+
+// reconcile re-applies the last decision when the relays are not in the decided state, e.g. when the relay was
+// toggled from the Shelly app or it rebooted. External changes are logged and recorded to the audit log. Manual
+// changes are kept as a temporary override instead when configured, which is reported by returning true.
+func (s *state) reconcile(ctx context.Context, now time.Time) (manual bool) {
+	if s.applied == nil || s.dryRun {
+		return false
+	}
+	d := *s.applied
+	status, err := s.cs.Status(ctx)
+	if err != nil {
+		log.Warn("failed to get relay status for reconciliation", "error", err)
+		return false
+	}
+	// boost is decided also without boost relay (e.g. always on price), so it is compared only when the relay exists
+	if status.Mode == d.mode && (s.cfg.Relays.BoostURL == "" || status.Boost == d.boost) {
+		return false
+	}
+
+	var changes []string
+	source := ""
+	for _, r := range status.Relays {
+		state := "off"
+		if r.On {
+			state = "on"
+		}
+		changes = append(changes, fmt.Sprintf("%s %s (%s)", r.Name, state, r.Source))
+		if r.On != relayOn(d, r.Name) && source == "" && isManual(r.Source) {
+			source = r.Source
+		}
+	}
+	driftCounter.Inc()
+
+	if source != "" && s.cfg.Relays.RespectManual && s.overrides != nil &&
+		s.keepManualChange(ctx, now, status, source, strings.Join(changes, ", ")) {
+		return true
+	}
+
+	log.Warn("relays changed outside the controller, applying the decision again", "expected", d.mode, "expectedBoost", d.boost,
+		"actual", status.Mode, "actualBoost", status.Boost, "relays", strings.Join(changes, ", "))
+	// frost protection may have started after the decision (e.g. a manual change was kept until now)
+	d = constrain(d, "frost protection", s.applyFrostProtection(d))
+	applied := d
+	s.applied = &applied
+	err = s.apply(ctx, d)
+	d.reason = fmt.Sprintf("relays changed outside the controller (%s), applied again: %s", strings.Join(changes, ", "), d.reason)
+	s.record(ctx, now, d, status.Relays, err)
+	return false
+}
+
+// keepManualChange sets a temporary override to the mode of the relays until the next control cycle (or the manual
+// timeout), so that the controller does not undo a manual change made on the relay. The change is not kept (false is
+// returned) when frost protection would override it.
+func (s *state) keepManualChange(ctx context.Context, now time.Time, status control.Status, source, changes string) bool {
+	if d := s.applyFrostProtection(decision{mode: status.Mode, boost: status.Boost}); d.mode != status.Mode {
+		log.Warn("relays changed manually, but frost protection overrides the change", "mode", status.Mode,
+			"source", source, "relays", changes)
+		return false
+	}
+
+	until := s.nextControl(now).Add(-time.Second)
+	if s.cfg.Relays.ManualTimeout > 0 {
+		until = now.Add(s.cfg.Relays.ManualTimeout)
+	}
+	o, err := s.overrides.SetManual(status.Mode, status.Boost, source, now, until)
+	if err != nil {
+		log.Error("failed to keep manual change", "error", err)
+		return false
+	}
+	log.Info("relays changed manually, keeping the change", "mode", o.Mode, "boost", o.Boost, "source", source,
+		"until", o.Until, "relays", changes)
+
+	d, _ := s.applyOverride(now)
+	d = constrain(d, "frost protection", s.applyFrostProtection(d))
+	d.strategy = "manual override"
+	s.applied = &d
+	s.record(ctx, now, d, status.Relays)
+	return true
+}
+
+// relayOn returns the state of the relay for the decision
+func relayOn(d decision, relay string) bool {
+	switch relay {
+	case "relay":
+		return d.mode == control.Lowered
+	case "evu":
+		return d.mode == control.EVUStop
+	case "boost":
+		return d.boost
+	}
+	return false
+}
+
+// isManual returns true if the relay change source is a manual change on the relay
+func isManual(source string) bool {
+	for _, m := range manualSources {
+		if strings.EqualFold(source, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/logging"
+)
+
+const configWatchInterval = 10 * time.Second
+
+// watchConfig sends to reload channel when configuration file changes or SIGHUP is received
+func watchConfig(path string, reload chan<- struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	w := newFileWatcher(path)
+	ticker := time.NewTicker(configWatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Info("SIGHUP received, reloading configuration")
+			reload <- struct{}{}
+		case <-ticker.C:
+			if w.changed() {
+				log.Info("configuration file changed, reloading configuration", "file", path)
+				reload <- struct{}{}
+			}
+		}
+	}
+}
+
+// fileWatcher detects file changes based on modification time and size
+type fileWatcher struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(path string) *fileWatcher {
+	w := &fileWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed returns true if the file has changed (or has been created or removed) since the last call
+func (w *fileWatcher) changed() bool {
+	if w.path == "" {
+		return false
+	}
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(w.path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	if modTime.Equal(w.modTime) && size == w.size {
+		return false
+	}
+	w.modTime, w.size = modTime, size
+	return true
+}
+
+// reload loads configuration and swaps in the new settings. Prices and temperature sources are kept unless their
+// configuration changed (replaced sources are closed). The current configuration is kept if the new one is not valid.
+func (s *state) reload(path string) error {
+	cfg, err := config.Load(path)
+	if err != nil {
+		log.Error("failed to reload configuration, keeping the current configuration", "error", err)
+		return err
+	}
+
+	changes := config.Diff(s.cfg, cfg)
+	if len(changes) == 0 {
+		if cfg.Calendar.File == "" {
+			log.Info("configuration not changed")
+			return nil
+		}
+		// calendar file may have changed
+		log.Info("configuration not changed, reloading calendar file", "file", cfg.Calendar.File)
+	}
+	for _, change := range changes {
+		log.Info("configuration changed", "change", change)
+	}
+
+	n, err := newState(cfg, s.dryRun, s.clock, s)
+	if err != nil {
+		log.Error("failed to reload configuration, keeping the current configuration", "error", err)
+		return err
+	}
+	if err = logging.Setup(os.Stdout, cfg.Log.Level, cfg.Log.Format); err != nil {
+		log.Error("failed to set up logging", "error", err)
+	}
+
+	// keep prices in memory unless price provider or bidding zone changed (pricing model is applied when reading prices)
+	if s.cfg.Provider.Name == cfg.Provider.Name && s.cfg.Provider.Zone == cfg.Provider.Zone {
+		s.sp.M.Lock()
+		n.sp.HourPrice = s.sp.HourPrice
+		s.sp.M.Unlock()
+	}
+
+	// replaced temperature sources are disconnected, so that e.g. MQTT subscriptions do not leak
+	prev := *s
+	*s = n
+	closeSources(prev.unusedSources(s)...)
+	return nil
+}
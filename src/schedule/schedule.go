@@ -0,0 +1,250 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const minutesPerDay = 24 * 60
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// Window is a time window within a day, optionally limited to some weekdays and a season (date range). Window
+// ending before it starts continues over midnight to the next day (weekday and season are checked for the start day).
+type Window struct {
+	from   int // minutes from midnight
+	to     int // minutes from midnight, 24:00 is 1440
+	days   uint8
+	season *season
+}
+
+type season struct {
+	from, to int // month*100 + day
+}
+
+// Schedule is a list of time windows
+type Schedule []Window
+
+// ParseWindow parses time window from string "[days] HH:MM-HH:MM [MM-DD..MM-DD]", e.g. "mon-fri 06:00-08:30",
+// "sat,sun 22:00-07:00 10-01..04-30" or "22:00-06:00". Days are comma separated weekdays (mon, tue, ..) or ranges
+// (mon-fri), "weekdays" or "weekend". A plain hour (e.g. "5") is the whole hour (05:00-06:00).
+func ParseWindow(str string) (w Window, err error) {
+	fields := strings.Fields(str)
+	if len(fields) == 0 {
+		return w, fmt.Errorf("empty schedule window")
+	}
+
+	if len(fields) == 1 {
+		if hour, err := strconv.Atoi(fields[0]); err == nil {
+			if hour < 0 || hour > 23 {
+				return w, fmt.Errorf("invalid hour: %d", hour)
+			}
+			return Window{from: hour * 60, to: (hour + 1) * 60}, nil
+		}
+	}
+
+	// optional days before the time range
+	if !strings.Contains(fields[0], ":") {
+		w.days, err = parseDays(fields[0])
+		if err != nil {
+			return w, err
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) == 0 || len(fields) > 2 {
+		return w, fmt.Errorf("invalid schedule window: %q", str)
+	}
+
+	times := strings.Split(fields[0], "-")
+	if len(times) != 2 {
+		return w, fmt.Errorf("invalid time range: %q", fields[0])
+	}
+	if w.from, err = parseTime(times[0]); err != nil {
+		return w, err
+	}
+	if w.to, err = parseTime(times[1]); err != nil {
+		return w, err
+	}
+	if w.from == minutesPerDay {
+		return w, fmt.Errorf("invalid start time: %q", times[0])
+	}
+
+	if len(fields) == 2 {
+		w.season, err = parseSeason(fields[1])
+		if err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
+
+// Parse parses a list of time windows
+func Parse(strs []string) (s Schedule, err error) {
+	for _, str := range strs {
+		w, err := ParseWindow(str)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+// Split splits environment variable into time windows. Windows are separated with semicolons, or with commas when
+// the value is a plain list of hours (e.g. "0,1,2,3,4,5").
+func Split(str string) (strs []string) {
+	sep := ","
+	if strings.ContainsAny(str, ";:") {
+		sep = ";"
+	}
+	for _, s := range strings.Split(str, sep) {
+		if s = strings.TrimSpace(s); s != "" {
+			strs = append(strs, s)
+		}
+	}
+	return strs
+}
+
+// Contains returns true if time is within the window
+func (w Window) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+
+	if w.from < w.to {
+		return m >= w.from && m < w.to && w.onDay(t)
+	}
+	if w.from == w.to {
+		// whole day
+		return w.onDay(t)
+	}
+	// window continues over midnight
+	if m >= w.from {
+		return w.onDay(t)
+	}
+	if m < w.to {
+		return w.onDay(t.AddDate(0, 0, -1))
+	}
+	return false
+}
+
+// Contains returns true if time is within any of the windows
+func (s Schedule) Contains(t time.Time) bool {
+	for _, w := range s {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the next time after t when a window starts or ends. Zero time is returned for an empty schedule.
+func (s Schedule) NextChange(t time.Time) (next time.Time) {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, w := range s {
+		for day := 0; day <= 1; day++ {
+			for _, m := range []int{w.from, w.to} {
+				change := midnight.AddDate(0, 0, day).Add(time.Duration(m) * time.Minute)
+				if change.After(t) && (next.IsZero() || change.Before(next)) {
+					next = change
+				}
+			}
+		}
+	}
+	return next
+}
+
+func (w Window) onDay(t time.Time) bool {
+	if w.days != 0 && w.days&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	if w.season != nil {
+		date := int(t.Month())*100 + t.Day()
+		if w.season.from <= w.season.to {
+			return date >= w.season.from && date <= w.season.to
+		}
+		// season continues over new year
+		return date >= w.season.from || date <= w.season.to
+	}
+	return true
+}
+
+func parseTime(str string) (int, error) {
+	t := strings.Split(str, ":")
+	if len(t) != 2 {
+		return 0, fmt.Errorf("invalid time (HH:MM): %q", str)
+	}
+	hour, err := strconv.Atoi(t[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid time (HH:MM): %q", str)
+	}
+	minute, err := strconv.Atoi(t[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid time (HH:MM): %q", str)
+	}
+	return hour*60 + minute, nil
+}
+
+func parseDays(str string) (days uint8, err error) {
+	switch str {
+	case "weekdays":
+		str = "mon-fri"
+	case "weekend":
+		str = "sat,sun"
+	}
+
+	for _, d := range strings.Split(str, ",") {
+		r := strings.Split(d, "-")
+		if len(r) > 2 {
+			return 0, fmt.Errorf("invalid days: %q", str)
+		}
+		from, ok := weekdays[r[0]]
+		if !ok {
+			return 0, fmt.Errorf("invalid weekday: %q", r[0])
+		}
+		to := from
+		if len(r) == 2 {
+			to, ok = weekdays[r[1]]
+			if !ok {
+				return 0, fmt.Errorf("invalid weekday: %q", r[1])
+			}
+		}
+		for day := from; ; day = (day + 1) % 7 {
+			days |= 1 << uint(day)
+			if day == to {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseSeason(str string) (*season, error) {
+	dates := strings.Split(str, "..")
+	if len(dates) != 2 {
+		return nil, fmt.Errorf("invalid season (MM-DD..MM-DD): %q", str)
+	}
+	var s season
+	for i, date := range dates {
+		t, err := time.Parse("01-02", date)
+		if err != nil {
+			return nil, fmt.Errorf("invalid season date (MM-DD): %q", date)
+		}
+		d := int(t.Month())*100 + t.Day()
+		if i == 0 {
+			s.from = d
+		} else {
+			s.to = d
+		}
+	}
+	return &s, nil
+}
@@ -0,0 +1,113 @@
+package schedule
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestParseWindow(t *testing.T) {
+	valid := []string{"5", "00:00-06:00", "mon-fri 06:00-08:30", "sat,sun 22:00-07:00 10-01..04-30", "weekdays 00:00-24:00", "fri-mon 12:00-13:00"}
+	for _, str := range valid {
+		if _, err := ParseWindow(str); err != nil {
+			t.Errorf("ParseWindow(%q) did not succeed: %s", str, err.Error())
+		}
+	}
+
+	invalid := []string{"", "24", "-1", "06:00", "xyz 06:00-07:00", "06:00-25:00", "24:00-01:00", "06:60-07:00", "06:00-07:00 13-01..14-01", "mon 06:00-07:00 extra field"}
+	for _, str := range invalid {
+		if _, err := ParseWindow(str); err == nil {
+			t.Errorf("ParseWindow(%q) should have failed, but it succeeded", str)
+		}
+	}
+}
+
+func TestSplit(t *testing.T) {
+	cases := map[string]struct {
+		str            string
+		expectedResult []string
+	}{
+		"Hours":   {str: "0,1, 2", expectedResult: []string{"0", "1", "2"}},
+		"Windows": {str: "mon-fri 06:00-08:00; sat,sun 08:00-10:00;", expectedResult: []string{"mon-fri 06:00-08:00", "sat,sun 08:00-10:00"}},
+	}
+
+	for k, tc := range cases {
+		result := Split(tc.str)
+		if !reflect.DeepEqual(result, tc.expectedResult) {
+			t.Fatalf("%s: Split\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+}
+
+func TestContains(t *testing.T) {
+	// 2022-10-28 is friday
+	friday := func(hour, minute int) time.Time { return time.Date(2022, 10, 28, hour, minute, 0, 0, time.UTC) }
+	saturday := func(hour, minute int) time.Time { return time.Date(2022, 10, 29, hour, minute, 0, 0, time.UTC) }
+	summer := time.Date(2022, 7, 1, 23, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		window         string
+		time           time.Time
+		expectedResult bool
+	}{
+		"Plain hour":                      {window: "5", time: friday(5, 59), expectedResult: true},
+		"Plain hour, next hour":           {window: "5", time: friday(6, 0), expectedResult: false},
+		"Minutes, before start":           {window: "06:30-08:15", time: friday(6, 29), expectedResult: false},
+		"Minutes, start":                  {window: "06:30-08:15", time: friday(6, 30), expectedResult: true},
+		"Minutes, end":                    {window: "06:30-08:15", time: friday(8, 15), expectedResult: false},
+		"Weekdays on friday":              {window: "weekdays 06:00-08:00", time: friday(7, 0), expectedResult: true},
+		"Weekdays on saturday":            {window: "weekdays 06:00-08:00", time: saturday(7, 0), expectedResult: false},
+		"Weekend on saturday":             {window: "weekend 06:00-08:00", time: saturday(7, 0), expectedResult: true},
+		"Day range over week":             {window: "fri-mon 06:00-08:00", time: saturday(7, 0), expectedResult: true},
+		"Over midnight, evening":          {window: "fri 22:00-06:00", time: friday(23, 0), expectedResult: true},
+		"Over midnight, next morning":     {window: "fri 22:00-06:00", time: saturday(5, 0), expectedResult: true},
+		"Over midnight, previous morning": {window: "fri 22:00-06:00", time: friday(5, 0), expectedResult: false},
+		"Whole day":                       {window: "00:00-24:00", time: friday(23, 59), expectedResult: true},
+		"Winter season in autumn":         {window: "22:00-23:30 10-01..04-30", time: friday(23, 0), expectedResult: true},
+		"Winter season in summer":         {window: "22:00-23:30 10-01..04-30", time: summer, expectedResult: false},
+		"Summer season in summer":         {window: "22:00-23:30 05-01..09-30", time: summer, expectedResult: true},
+	}
+
+	for k, tc := range cases {
+		w, err := ParseWindow(tc.window)
+		if err != nil {
+			t.Fatalf("%s: ParseWindow did not succeed: %s", k, err.Error())
+		}
+		result := w.Contains(tc.time)
+		if result != tc.expectedResult {
+			t.Fatalf("%s: Contains\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+}
+
+func TestNextChange(t *testing.T) {
+	s, err := Parse([]string{"06:30-08:15", "22:00-06:00"})
+	if err != nil {
+		t.Fatalf("Parse did not succeed: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		time           time.Time
+		expectedResult time.Time
+	}{
+		"Morning":    {time: time.Date(2022, 10, 28, 5, 0, 0, 0, time.UTC), expectedResult: time.Date(2022, 10, 28, 6, 0, 0, 0, time.UTC)},
+		"At change":  {time: time.Date(2022, 10, 28, 6, 30, 0, 0, time.UTC), expectedResult: time.Date(2022, 10, 28, 8, 15, 0, 0, time.UTC)},
+		"Late night": {time: time.Date(2022, 10, 28, 23, 0, 0, 0, time.UTC), expectedResult: time.Date(2022, 10, 29, 6, 0, 0, 0, time.UTC)},
+	}
+
+	for k, tc := range cases {
+		result := s.NextChange(tc.time)
+		if !result.Equal(tc.expectedResult) {
+			t.Fatalf("%s: NextChange\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+
+	if !(Schedule{}).NextChange(time.Now()).IsZero() {
+		t.Fatalf("NextChange of empty schedule should be zero")
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/temperature"
+)
+
+// shutdown saves the prices and leaves the relays in the configured safe state (usually NORMAL, so that a stopped
+// controller does not leave the house in ROOM LOWERING) and disconnects the temperature sources. Called after the control
+// loop has stopped with a context that limits the time spent on relay requests.
+func (s *state) shutdown(ctx context.Context) error {
+	s.storePrices()
+	closeSources(s.indoor.source, s.outdoor.source)
+
+	mode := strings.ToLower(s.cfg.Shutdown.Mode)
+	if mode == config.ShutdownKeep || mode == "" {
+		log.Info("leaving relays as they are")
+		return nil
+	}
+	m, err := control.ParseMode(mode)
+	if err != nil {
+		return err
+	}
+	d := decision{mode: m, strategy: "shutdown", reason: "controller stopped"}
+	before, statusErr := s.auditRelays(ctx)
+	err = s.apply(ctx, d)
+	s.record(ctx, s.clock.Now(), d, before, statusErr, err)
+	return err
+}
+
+// closeSources disconnects the temperature sources that keep a connection (e.g. MQTT)
+func closeSources(sources ...temperature.Source) {
+	for _, source := range sources {
+		if c, ok := source.(io.Closer); ok {
+			if err := c.Close(); err != nil {
+				log.Warn("failed to close temperature source", "error", err)
+			}
+		}
+	}
+}
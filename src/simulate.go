@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/pricestore"
+	"github.com/koovee/thermia/schedule"
+	"github.com/koovee/thermia/spotprice"
+)
+
+const defaultNightWindow = "22:00-07:00"
+
+// tariff is the fixed day/night electricity tariff that the strategy is compared with. Prices are total prices
+// (c/kWh) of day and night hours, zero when the tariff is not given.
+type tariff struct {
+	nightWindow string
+	dayPrice    float64
+	nightPrice  float64
+}
+
+// simulationDay is the result of replaying the prices of a day. Sums are total prices (c/kWh) of the hours.
+type simulationDay struct {
+	day          time.Time
+	heatingHours int
+	loweredHours int
+	missingHours int // hours without price
+	heatingSum   float64
+	allHours     int
+	allSum       float64
+	nightHours   int
+	nightSum     float64
+}
+
+// newSimulationState returns state for replaying historical prices through the configured strategy and constraints.
+// Temperatures, manual override and audit log are not simulated.
+func newSimulationState(cfg config.Config, prices map[string][]float64) (s state, err error) {
+	cfg.Outdoor = config.Outdoor{}
+	cfg.Indoor = config.Indoor{}
+	cfg.Frost.OutdoorLimit = nil
+	cfg.Override.File = ""
+	cfg.Audit.File = ""
+	cfg.Prices.File = ""
+	s, err = newState(cfg, true, clock.Real, nil)
+	if err != nil {
+		return s, err
+	}
+	s.quiet = true
+	s.sp.HourPrice = spotprice.HourPrices(prices)
+	return s, nil
+}
+
+// loadSimulationPrices reads prices from a CSV file or from the price store (empty path)
+func loadSimulationPrices(cfg config.Config, path string, loc *time.Location) (map[string][]float64, error) {
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open price file: %w", err)
+		}
+		defer f.Close()
+		return pricestore.ReadCSV(f, loc)
+	}
+	if cfg.Prices.File == "" {
+		return nil, errors.New("price store is disabled (PRICE_FILE), use a price file")
+	}
+	store, err := pricestore.Open(cfg.Prices.File)
+	if err != nil {
+		return nil, err
+	}
+	return store.All(), nil
+}
+
+// runSimulation replays prices of the time range and prints the results
+func runSimulation(w io.Writer, cfg config.Config, timeRange, priceFile string, power float64, tr tariff) error {
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return err
+	}
+	from, to, err := parseTimeRange(timeRange, loc)
+	if err != nil {
+		return err
+	}
+	night, err := schedule.Parse([]string{tr.nightWindow})
+	if err != nil {
+		return fmt.Errorf("invalid night window: %w", err)
+	}
+	if power <= 0 {
+		return fmt.Errorf("power must be positive (%v)", power)
+	}
+	if tr.dayPrice < 0 || tr.nightPrice < 0 || (tr.dayPrice > 0) != (tr.nightPrice > 0) {
+		return fmt.Errorf("day and night prices must both be positive or both unset (%v, %v)", tr.dayPrice, tr.nightPrice)
+	}
+	prices, err := loadSimulationPrices(cfg, priceFile, loc)
+	if err != nil {
+		return err
+	}
+	s, err := newSimulationState(cfg, prices)
+	if err != nil {
+		return err
+	}
+	printSimulation(w, s.simulate(from, to, night), power, tr)
+	return nil
+}
+
+// simulate replays the prices of the time range hour by hour through the strategy. Heating hours are compared with
+// heating every hour and heating only during the night window.
+func (s state) simulate(from, to time.Time, night schedule.Schedule) (days []simulationDay) {
+	var current *simulationDay
+	for t := from; t.Before(to); t = t.Add(time.Hour) {
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+		if current == nil || !current.day.Equal(day) {
+			days = append(days, simulationDay{day: day})
+			current = &days[len(days)-1]
+		}
+
+		price, err := s.sp.GetPrice(t)
+		if err != nil {
+			current.missingHours++
+			continue
+		}
+		current.allHours++
+		current.allSum += price
+		if night.Contains(t) {
+			current.nightHours++
+			current.nightSum += price
+		}
+		if d := s.decide(t); d.mode == control.Normal {
+			current.heatingHours++
+			current.heatingSum += price
+		} else {
+			current.loweredHours++
+		}
+	}
+	return days
+}
+
+// printSimulation prints heating hours and prices per day and estimated costs of the strategy compared to heating
+// every hour and heating only at night with spot prices, and with the day/night tariff when it is given. Costs assume
+// the heat pump uses power (kW) when heating.
+func printSimulation(w io.Writer, days []simulationDay, power float64, tr tariff) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "Day\tHeating (h)\tLowered (h)\tNo price (h)\tAvg price (c/kWh)\tCost (€)\t\n")
+	var total simulationDay
+	for _, d := range days {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%.2f\t\n", d.day.Format("2006-01-02"), d.heatingHours, d.loweredHours,
+			d.missingHours, average(d.heatingSum, d.heatingHours), d.heatingSum*power/100)
+		total.heatingHours += d.heatingHours
+		total.loweredHours += d.loweredHours
+		total.missingHours += d.missingHours
+		total.heatingSum += d.heatingSum
+		total.allHours += d.allHours
+		total.allSum += d.allSum
+		total.nightHours += d.nightHours
+		total.nightSum += d.nightSum
+	}
+	fmt.Fprintf(tw, "Total\t%d\t%d\t%d\t%s\t%.2f\t\n", total.heatingHours, total.loweredHours, total.missingHours,
+		average(total.heatingSum, total.heatingHours), total.heatingSum*power/100)
+	tw.Flush()
+
+	fmt.Fprintf(w, "\nEstimated with %.1f kW when heating:\n", power)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "\tHeating (h)\tAvg price (c/kWh)\tCost (€)\tStrategy saves\t\n")
+	type baseline struct {
+		name  string
+		hours int
+		sum   float64
+	}
+	baselines := []baseline{
+		{name: "Strategy", hours: total.heatingHours, sum: total.heatingSum},
+		{name: "Always on", hours: total.allHours, sum: total.allSum},
+		{name: fmt.Sprintf("Night at spot (%s)", tr.nightWindow), hours: total.nightHours, sum: total.nightSum},
+	}
+	if tr.nightPrice > 0 {
+		nightSum := float64(total.nightHours) * tr.nightPrice
+		daySum := float64(total.allHours-total.nightHours) * tr.dayPrice
+		baselines = append(baselines,
+			baseline{name: fmt.Sprintf("Night tariff (%s)", tr.nightWindow), hours: total.nightHours, sum: nightSum},
+			baseline{name: "Day/night tariff", hours: total.allHours, sum: daySum + nightSum})
+	}
+	for i, b := range baselines {
+		// saving is the difference of average prices, so that baselines with different heating hours are comparable
+		saving := "-"
+		if i > 0 && b.hours > 0 && total.heatingHours > 0 && b.sum != 0 {
+			strategyAvg := total.heatingSum / float64(total.heatingHours)
+			avg := b.sum / float64(b.hours)
+			saving = fmt.Sprintf("%.1f %%", (avg-strategyAvg)/abs(avg)*100)
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%.2f\t%s\t\n", b.name, b.hours, average(b.sum, b.hours), b.sum*power/100, saving)
+	}
+	tw.Flush()
+}
+
+func average(sum float64, n int) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f", sum/float64(n))
+}
+
+func abs(f float64) float64 {
+	if f < 0 {
+		return -f
+	}
+	return f
+}
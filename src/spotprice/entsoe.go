@@ -1,62 +1,58 @@
 package spotprice
 
 import (
-	"encoding/xml"
+	"context"
 	"errors"
-	"fmt"
-	"io"
+	"math"
 	"net/http"
 	"net/url"
-	"os"
-	"strconv"
+	"sort"
 	"sync"
 	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/koovee/thermia/logging"
 )
 
 const (
-	apiUrl     = "https://web-api.tp.entsoe.eu/api"
-	DateLayout = "20060102"
-	highPrice  = 9999.99
+	apiUrl      = "https://web-api.tp.entsoe.eu/api"
+	DateLayout  = "20060102"
+	highPrice   = 9999.99
+	defaultZone = "10YFI-1--------U"
 )
 
+var log = logging.Component("spotprice")
+
 type State struct {
 	token     string
+	zone      string
+	pricing   Pricing
 	threshold float64
 	maxPrice  float64
 	HourPrice HourPrices
 	C         chan bool
 	M         *sync.Mutex
 	hc        http.Client
+	url       string
+	clock     clock.Clock
 }
 
-type A44Response struct {
-	XMLName    xml.Name `xml:"Publication_MarketDocument"`
-	TimeSeries []struct {
-		XMLName xml.Name `xml:"TimeSeries"`
-		MRID    string   `xml:"mRID"`
-		Period  struct {
-			XMLName      xml.Name `xml:"Period"`
-			TimeInterval struct {
-				XMLName xml.Name `xml:"timeInterval"`
-				Start   string   `xml:"start"`
-				End     string   `xml:"end"`
-			}
-			Resolution string `xml:"resolution"`
-			Point      []struct {
-				XMLName  xml.Name `xml:"Point"`
-				Position int      `xml:"position"`
-				Price    string   `xml:"price.amount"`
-			}
-		}
+// InitWithConfig initializes the module with a given configuration. Token is required.
+func (s *State) InitWithConfig(c Config) error {
+	if c.Token == "" {
+		return errors.New("token not set")
 	}
-}
-
-func (s *State) Init() (err error) {
-	err = s.getEnv()
-	if err != nil {
-		fmt.Printf("failed to get required environment variables: %s\n", err.Error())
-		return err
+	s.token = c.Token
+	s.zone = c.Zone
+	if s.zone == "" {
+		s.zone = defaultZone
+	}
+	s.pricing = c.Pricing
+	s.url = c.URL
+	if s.url == "" {
+		s.url = apiUrl
 	}
+	s.clock = c.Clock
 
 	s.hc = http.Client{
 		Transport:     nil,
@@ -72,149 +68,137 @@ func (s *State) Init() (err error) {
 	return nil
 }
 
-// GetPrice returns price in c/kWh
+// now returns the current time of the clock (real clock if not set)
+func (s State) now() time.Time {
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// GetPrice returns total price in c/kWh
 func (s State) GetPrice(time time.Time) (float64, error) {
 	s.M.Lock()
 	defer s.M.Unlock()
 	hour := time.Hour()
-	if len(s.HourPrice[time.Format(DateLayout)]) < hour {
-		fmt.Printf("no pricing available for %s hour %d\n", time.String(), hour)
+	if len(s.HourPrice[time.Format(DateLayout)]) <= hour {
+		log.Debug("no pricing available", "time", time)
 		return 0, errors.New("no price information available")
 	}
-	return s.HourPrice[time.Format(DateLayout)][hour] / 10, nil
+	return s.pricing.Total(s.HourPrice[time.Format(DateLayout)][hour] / 10), nil
 }
 
-// UpdateSpotPrices ..
-func (s *State) UpdateSpotPrices() {
-	var retryCount = 0
-
-	yesterday := time.Now().Add(-24 * time.Hour).Format(DateLayout)
-	day := time.Now().Format(DateLayout)
-	tomorrow := time.Now().Add(24 * time.Hour).Format(DateLayout)
+// DayPrices returns total prices (c/kWh) for the day of a given time
+func (s State) DayPrices(time time.Time) []float64 {
+	s.M.Lock()
+	defer s.M.Unlock()
+	var prices []float64
+	for _, price := range s.HourPrice[time.Format(DateLayout)] {
+		prices = append(prices, s.pricing.Total(price/10))
+	}
+	return prices
+}
 
+// PricesFrom returns total prices (c/kWh) for n hours starting from the hour of a given time. Fewer than n prices are
+// returned if pricing is not available for the whole period.
+func (s State) PricesFrom(from time.Time, n int) []float64 {
 	s.M.Lock()
 	defer s.M.Unlock()
+	var prices []float64
+	t := from.Truncate(time.Hour)
+	for i := 0; i < n; i, t = i+1, t.Add(time.Hour) {
+		dayPrices := s.HourPrice[t.Format(DateLayout)]
+		if t.Hour() >= len(dayPrices) {
+			break
+		}
+		prices = append(prices, s.pricing.Total(dayPrices[t.Hour()]/10))
+	}
+	return prices
+}
+
+// UpdateSpotPrices fetches today's prices, and tomorrow's prices from 18:00, unless they are already known. Request
+// is cancelled when ctx is done.
+func (s *State) UpdateSpotPrices(ctx context.Context) {
+	now := s.now()
+	yesterday := now.AddDate(0, 0, -1).Format(DateLayout)
+	day := now.Format(DateLayout)
+	tomorrow := now.AddDate(0, 0, 1).Format(DateLayout)
 
-	periodStart := day + "0000"
-	periodEnd := tomorrow + "0000"
+	s.M.Lock()
+	defer s.M.Unlock()
 
-	if time.Now().Hour() > 18 && len(s.HourPrice[day]) > 0 {
-		if len(s.HourPrice[tomorrow]) < 24 {
-			periodStart = tomorrow + "0000"
-			periodEnd = tomorrow + "0100"
-			day = tomorrow
-			// TODO: change variable name...
-		} else {
+	from := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if now.Hour() >= 18 && len(s.HourPrice[day]) > 0 {
+		if len(s.HourPrice[tomorrow]) >= 24 {
 			// enough pricing data in store..
 			return
 		}
+		from = from.AddDate(0, 0, 1)
 	} else if len(s.HourPrice[day]) > 0 {
 		// enough pricing data in store..
 		return
 	}
+	to := from.AddDate(0, 0, 1)
 
-	fmt.Printf("getting spot prices from %s\n", apiUrl)
+	log.Info("getting spot prices", "url", s.url, "from", from, "to", to)
 
 	// delete yesterdays records
-	fmt.Printf("DEBUG: map size before cleanup: %d\n", len(s.HourPrice))
 	delete(s.HourPrice, yesterday)
-	fmt.Printf("DEBUG: map size after cleanup: %d\n", len(s.HourPrice))
+	log.Debug("removed old prices", "day", yesterday, "days", len(s.HourPrice))
 
-	req, err := http.NewRequest("GET", apiUrl, nil)
+	prices, reason, err := s.fetch(ctx, from, to)
 	if err != nil {
-		fmt.Printf("Failed to create http request\n")
-		retryCount++
-		time.Sleep(time.Second * time.Duration(retryCount*retryCount))
+		fetchFailures.WithLabelValues(reason).Inc()
+		log.Error("failed to get spot prices", "error", err)
 		return
 	}
-	retryCount = 0
-
-	q := url.Values{}
-	q.Add("securityToken", s.token)
-	q.Add("documentType", "A44")
-	q.Add("In_domain", "10YFI-1--------U")
-	q.Add("out_domain", "10YFI-1--------U")
-	q.Add("periodStart", periodStart)
-	q.Add("periodEnd", periodEnd)
-	req.URL.RawQuery = q.Encode()
-
-	resp, err := s.hc.Do(req)
-	if err != nil {
-		fmt.Printf("failed to make http request: %s\n", err.Error())
-		return
-	}
-	defer resp.Body.Close()
-
-	fmt.Printf("DEBUG: %s\n", req.URL)
-	fmt.Printf("DEBUG: %v\n", resp.Status)
-
-	hourlyPrices := A44Response{}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("failed to read http response body\n")
+	if len(prices) == 0 {
+		fetchFailures.WithLabelValues("empty").Inc()
+		log.Warn("no spot prices in the response")
 		return
 	}
-	//fmt.Printf("body: %s\n", body)
 
-	if err = xml.Unmarshal(body, &hourlyPrices); err != nil {
-		fmt.Printf("failed to unmarshal xml\n")
-		return
-	}
-
-	for _, v := range hourlyPrices.TimeSeries {
-		var p []float64
-
-		periodStart, err := time.Parse("2006-01-02T15:04Z", v.Period.TimeInterval.Start)
-		if err != nil {
-			fmt.Printf("failed to parse Period.TimeInterval.Start: %s\n", err.Error())
-			fmt.Printf("DEBUG response body: %s\n", body)
-			return
-		}
-		day = periodStart.Add(time.Hour * 24).Format(DateLayout)
-
-		// entsoe data starts from 00:00 no matter what is requested
-		// if period.Point is missing, use previous value!!
-
-		currentPosition := 0
-		previousPrice := highPrice
-		for _, v := range v.Period.Point {
-			if v.Position > 24 {
-				fmt.Printf("DEBUG: v.Position larger than 24 -- check entsoe response!!\n")
-				fmt.Printf("DEBUG response body: %s\n", body)
-			} else {
-				for ; currentPosition < v.Position-1; currentPosition++ {
-					p = append(p, previousPrice)
-				}
-
-				previousPrice, err = strconv.ParseFloat(v.Price, 64)
-				if err != nil {
-					fmt.Printf("failed to convert price to float\n")
-					return
-				}
-				//				previousPrice *= 1.24
-				p = append(p, previousPrice)
-				currentPosition++
+	// prices are indexed by hour of the day: hour skipped by daylight saving time change (or missing from the
+	// response) repeats the previous price and the first of the repeated hours is used
+	days := make(map[string][]float64)
+	for _, p := range prices {
+		d, hour := p.Time.Format(DateLayout), p.Time.Hour()
+		hours := days[d]
+		for len(hours) < hour {
+			previous := highPrice
+			if len(hours) > 0 {
+				previous = hours[len(hours)-1]
 			}
+			hours = append(hours, previous)
 		}
-		s.HourPrice[day] = p[:24]
+		if len(hours) == hour {
+			hours = append(hours, p.Price)
+		}
+		days[d] = hours
+	}
+	for d, hours := range days {
+		s.HourPrice[d] = hours
 	}
+	lastFetch.set(s.now(), s.clock)
 
-	// DEBUG
 	for day, prices := range s.HourPrice {
-		fmt.Printf("%s: ", day)
-		for hour, price := range prices {
-			fmt.Printf("%d:%v ", hour, price)
-		}
-		fmt.Printf("\n")
+		log.Debug("spot prices (EUR/MWh)", "day", day, "prices", prices)
 	}
 }
 
-// CheapestHours returns the cheapest n hours for a given day
-func (s State) CheapestHours(n int) (cheapestPrices []int) {
+// CheapestHours returns the cheapest n hours for today
+func (s State) CheapestHours(n int) []int {
+	return s.CheapestHoursOn(s.now(), n)
+}
+
+// CheapestHoursOn returns the cheapest n hours for the day of a given time
+func (s State) CheapestHoursOn(day time.Time, n int) (cheapestPrices []int) {
 	var cheapestIndex int
 	var cheapest float64
 
-	prices := s.HourPrice[time.Now().Format(DateLayout)]
+	s.M.Lock()
+	defer s.M.Unlock()
+	prices := s.HourPrice[day.Format(DateLayout)]
 
 	for i := 0; i < n; i++ {
 		cheapest = highPrice
@@ -234,10 +218,153 @@ func (s State) CheapestHours(n int) (cheapestPrices []int) {
 	return cheapestPrices
 }
 
-func (s *State) getEnv() error {
-	s.token = os.Getenv("TOKEN")
-	if s.token == "" {
-		return errors.New("TOKEN not set")
+// CheapestBlocks returns the hours of the cheapest non-overlapping contiguous blocks starting today
+func (s State) CheapestBlocks(lengths []int) []time.Time {
+	return s.CheapestBlocksOn(s.now(), lengths)
+}
+
+// CheapestBlocksOn returns the hours (start of each hour) of the cheapest non-overlapping contiguous blocks starting
+// on the day of a given time. Blocks are in any order, e.g. lengths [3, 2] may return a 2 hour block followed by a 3
+// hour block. Blocks may continue past midnight when the prices of the next day are known. Nil is returned if the
+// blocks do not fit into the available prices.
+func (s State) CheapestBlocksOn(day time.Time, lengths []int) (cheapestHours []time.Time) {
+	total := 0
+	for _, length := range lengths {
+		if length <= 0 {
+			return nil
+		}
+		total += length
 	}
-	return nil
+	if total == 0 {
+		return nil
+	}
+
+	// prices from the start of the day until the end of the known prices (at most until the end of the next day)
+	var hours []time.Time
+	var prices []float64
+	first := 0 // number of hours on the day, blocks must start on the day
+	key := day.Format(DateLayout)
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	end := start.AddDate(0, 0, 2)
+	s.M.Lock()
+	for t := start; t.Before(end); t = t.Add(time.Hour) {
+		dayPrices := s.HourPrice[t.Format(DateLayout)]
+		if t.Hour() >= len(dayPrices) {
+			break
+		}
+		hours = append(hours, t)
+		prices = append(prices, dayPrices[t.Hour()])
+		if t.Format(DateLayout) == key {
+			first++
+		}
+	}
+	s.M.Unlock()
+	if total > len(prices) {
+		return nil
+	}
+
+	blocks := cheapestBlocks(prices, first, lengths)
+	if blocks == nil {
+		return nil
+	}
+	for _, b := range blocks {
+		cheapestHours = append(cheapestHours, hours[b.start:b.start+b.length]...)
+	}
+	sort.Slice(cheapestHours, func(i, j int) bool { return cheapestHours[i].Before(cheapestHours[j]) })
+	return cheapestHours
+}
+
+// block is a contiguous block of hours (indexes of prices)
+type block struct {
+	start  int
+	length int
+}
+
+// cheapestBlocks returns the cheapest non-overlapping blocks of given lengths (in any order) that start before index
+// first. Nil is returned if the blocks do not fit into prices.
+func cheapestBlocks(prices []float64, first int, lengths []int) []block {
+	// blocks of the same length are interchangeable, so the remaining blocks are counts of each distinct length. The
+	// counts are encoded as a mixed radix number (state).
+	var distinct, counts []int
+	for _, length := range lengths {
+		i := sort.SearchInts(distinct, length)
+		if i < len(distinct) && distinct[i] == length {
+			counts[i]++
+			continue
+		}
+		distinct = append(distinct[:i], append([]int{length}, distinct[i:]...)...)
+		counts = append(counts[:i], append([]int{1}, counts[i:]...)...)
+	}
+	radix := make([]int, len(distinct))
+	states := 1
+	for i := range distinct {
+		radix[i] = states
+		states *= counts[i] + 1
+	}
+
+	// cost[state][i] is the cheapest total price of the remaining blocks when the blocks start at hour i or later
+	// next[state][i] is the start hour and the index of the length of the first block for cost[state][i]
+	type choice struct{ start, length int }
+	cost := make([][]float64, states)
+	next := make([][]choice, states)
+	for state := range cost {
+		cost[state] = make([]float64, len(prices)+1)
+		next[state] = make([]choice, len(prices)+1)
+	}
+	for state := 1; state < states; state++ {
+		for i := len(prices); i >= 0; i-- {
+			cost[state][i] = math.Inf(1)
+			next[state][i] = choice{start: -1}
+			if i < len(prices) {
+				cost[state][i] = cost[state][i+1]
+				next[state][i] = next[state][i+1]
+			}
+			if i >= first {
+				continue
+			}
+			// earlier start wins ties
+			best, bestLength := math.Inf(1), -1
+			for k, length := range distinct {
+				if (state/radix[k])%(counts[k]+1) == 0 || i+length > len(prices) {
+					continue
+				}
+				sum := cost[state-radix[k]][i+length]
+				for h := i; h < i+length; h++ {
+					sum += prices[h]
+				}
+				if sum < best {
+					best, bestLength = sum, k
+				}
+			}
+			if bestLength >= 0 && best <= cost[state][i] {
+				cost[state][i] = best
+				next[state][i] = choice{start: i, length: bestLength}
+			}
+		}
+	}
+
+	var blocks []block
+	i := 0
+	for state := states - 1; state > 0; {
+		c := next[state][i]
+		if c.start < 0 || math.IsInf(cost[state][i], 1) {
+			return nil
+		}
+		blocks = append(blocks, block{start: c.start, length: distinct[c.length]})
+		i = c.start + distinct[c.length]
+		state -= radix[c.length]
+	}
+	return blocks
+}
+
+// query returns the query parameters of a day-ahead price (A44) request. Period is yyyyMMddHHmm.
+func (s State) query(periodStart, periodEnd string) url.Values {
+	q := url.Values{}
+	q.Add("securityToken", s.token)
+	q.Add("documentType", "A44")
+	q.Add("In_domain", s.zone)
+	q.Add("out_domain", s.zone)
+	q.Add("periodStart", periodStart)
+	q.Add("periodEnd", periodEnd)
+	return q
 }
@@ -0,0 +1,152 @@
+package spotprice
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	periodLayout   = "200601021504"
+	intervalLayout = "2006-01-02T15:04Z"
+)
+
+// Price is the spot price (EUR/MWh) of an hour
+type Price struct {
+	Time  time.Time
+	Price float64
+}
+
+// a44Document is a day-ahead price document with all periods of the time series (multi-day requests return several
+// periods, possibly with sub-hour resolution)
+type a44Document struct {
+	TimeSeries []struct {
+		Period []struct {
+			TimeInterval struct {
+				Start string `xml:"start"`
+				End   string `xml:"end"`
+			} `xml:"timeInterval"`
+			Resolution string `xml:"resolution"`
+			Point      []struct {
+				Position int    `xml:"position"`
+				Price    string `xml:"price.amount"`
+			} `xml:"Point"`
+		} `xml:"Period"`
+	} `xml:"TimeSeries"`
+}
+
+// FetchRange fetches day-ahead prices between from and to with one request. Prices are normalized to hours: missing
+// positions repeat the previous price and sub-hour prices are averaged. Caller is responsible for keeping the range
+// within API limits (one year).
+func (s State) FetchRange(ctx context.Context, from, to time.Time) ([]Price, error) {
+	prices, _, err := s.fetch(ctx, from, to)
+	return prices, err
+}
+
+// fetch fetches hourly prices between from and to. Reason of a failure is returned for metrics.
+func (s State) fetch(ctx context.Context, from, to time.Time) (prices []Price, reason string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, "request", fmt.Errorf("failed to create http request: %w", err)
+	}
+	req.URL.RawQuery = s.query(from.UTC().Format(periodLayout), to.UTC().Format(periodLayout)).Encode()
+
+	log.Debug("getting spot prices", "url", s.url, "from", from, "to", to)
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		return nil, "request", fmt.Errorf("failed to make http request: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "read", fmt.Errorf("failed to read http response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// acknowledgement document explains e.g. that no data is available yet
+		return nil, "status", fmt.Errorf("failed to get spot prices: %s", resp.Status)
+	}
+
+	doc := a44Document{}
+	if err = xml.Unmarshal(body, &doc); err != nil {
+		return nil, "xml", fmt.Errorf("failed to unmarshal xml: %w", err)
+	}
+	if prices, err = doc.hourPrices(from, to); err != nil {
+		return nil, "data", err
+	}
+	return prices, "", nil
+}
+
+// hourPrices returns hourly prices of the document between from and to in time order
+func (doc a44Document) hourPrices(from, to time.Time) ([]Price, error) {
+	sums := make(map[time.Time]float64)
+	counts := make(map[time.Time]int)
+	for _, ts := range doc.TimeSeries {
+		for _, period := range ts.Period {
+			start, err := time.Parse(intervalLayout, period.TimeInterval.Start)
+			if err != nil {
+				return nil, fmt.Errorf("invalid period start: %q", period.TimeInterval.Start)
+			}
+			end, err := time.Parse(intervalLayout, period.TimeInterval.End)
+			if err != nil {
+				return nil, fmt.Errorf("invalid period end: %q", period.TimeInterval.End)
+			}
+			resolution, err := parseResolution(period.Resolution)
+			if err != nil {
+				return nil, err
+			}
+
+			n := int(end.Sub(start) / resolution)
+			prices := make([]float64, n)
+			known := make([]bool, n)
+			for _, p := range period.Point {
+				if p.Position < 1 || p.Position > n {
+					log.Warn("ignoring price with position outside the period", "position", p.Position, "start", start)
+					continue
+				}
+				prices[p.Position-1], err = strconv.ParseFloat(strings.TrimSpace(p.Price), 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid price: %q", p.Price)
+				}
+				known[p.Position-1] = true
+			}
+			for i := range prices {
+				if !known[i] {
+					if i == 0 || !known[i-1] {
+						// no previous price to repeat
+						continue
+					}
+					prices[i] = prices[i-1]
+					known[i] = true
+				}
+				hour := start.Add(time.Duration(i) * resolution).Truncate(time.Hour)
+				sums[hour] += prices[i]
+				counts[hour]++
+			}
+		}
+	}
+
+	var prices []Price
+	for hour, sum := range sums {
+		if hour.Before(from) || !hour.Before(to) {
+			continue
+		}
+		prices = append(prices, Price{Time: hour.In(from.Location()), Price: sum / float64(counts[hour])})
+	}
+	sort.Slice(prices, func(i, j int) bool { return prices[i].Time.Before(prices[j].Time) })
+	return prices, nil
+}
+
+// parseResolution parses resolution of a period (PT15M, PT30M or PT60M)
+func parseResolution(str string) (time.Duration, error) {
+	d, err := time.ParseDuration(strings.ToLower(strings.TrimPrefix(str, "PT")))
+	if err != nil || d <= 0 || time.Hour%d != 0 {
+		return 0, fmt.Errorf("unsupported resolution: %q", str)
+	}
+	return d, nil
+}
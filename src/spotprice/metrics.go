@@ -0,0 +1,49 @@
+package spotprice
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	fetchFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "thermia_spotprice_fetch_failures_total",
+		Help: "Number of failed spot price fetches by reason",
+	}, []string{"reason"})
+	lastFetch = &fetchTime{}
+	_         = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thermia_spotprice_hours_since_last_fetch",
+		Help: "Hours since the last successful spot price fetch (NaN before the first fetch)",
+	}, lastFetch.hoursSince)
+)
+
+// fetchTime is the time of the last successful spot price fetch (zero before the first fetch) and the clock that the
+// time since the fetch is measured with
+type fetchTime struct {
+	m     sync.Mutex
+	t     time.Time
+	clock clock.Clock
+}
+
+func (f *fetchTime) set(t time.Time, clk clock.Clock) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if clk == nil {
+		clk = clock.Real
+	}
+	f.t, f.clock = t, clk
+}
+
+func (f *fetchTime) hoursSince() float64 {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.t.IsZero() {
+		return math.NaN()
+	}
+	return f.clock.Now().Sub(f.t).Hours()
+}
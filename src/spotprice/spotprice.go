@@ -1,21 +1,65 @@
 package spotprice
 
 import (
+	"math"
+	"sort"
 	"time"
+
+	"github.com/koovee/thermia/clock"
 )
 
 type SpotPrice interface {
-	Init() error
+	InitWithConfig(c Config) error
 	// GetPrice returns price for a given time
 	GetPrice(time time.Time) float64
+	// DayPrices returns prices for the day of a given time
+	DayPrices(time time.Time) []float64
+	// PricesFrom returns prices for n hours starting from a given time
+	PricesFrom(from time.Time, n int) []float64
 	// UpdatePrices retrieves price updates from 3rd party provider
 	UpdatePrices() error
 	// CheapestHours returns the cheapest n hours for a given day
 	CheapestHours(n int) []int
+	// CheapestBlocks returns the hours of the cheapest contiguous blocks of given lengths starting today
+	CheapestBlocks(lengths []int) []time.Time
 }
 
 type HourPrices map[string][]float64
 
+// Config is the spot price provider configuration
+type Config struct {
+	Token   string
+	Zone    string
+	Pricing Pricing
+	URL     string      // API URL (default: ENTSO-E transparency platform)
+	Clock   clock.Clock // default: real clock
+}
+
+// Pricing converts spot price to total price
+type Pricing struct {
+	VAT      float64 // percent, not added to negative spot prices
+	Margin   float64 // c/kWh
+	Transfer float64 // c/kWh
+}
+
+// Total returns total price (c/kWh) for a given spot price (c/kWh)
+func (p Pricing) Total(spot float64) float64 {
+	if spot > 0 {
+		spot *= 1 + p.VAT/100
+	}
+	return spot + p.Margin + p.Transfer
+}
+
+// IsCheapestTime tells whether the hour of t is one of the cheapest hours (e.g. hours of the cheapest blocks)
+func IsCheapestTime(t time.Time, cheapestHours []time.Time) bool {
+	for _, cheapestHour := range cheapestHours {
+		if d := t.Sub(cheapestHour); d >= 0 && d < time.Hour {
+			return true
+		}
+	}
+	return false
+}
+
 func IsCheapestHour(hour int, cheapestHours []int) bool {
 	for _, cheapestHour := range cheapestHours {
 		if cheapestHour == hour {
@@ -24,3 +68,28 @@ func IsCheapestHour(hour int, cheapestHours []int) bool {
 	}
 	return false
 }
+
+// Percentile returns the p-th percentile (0-100) of prices using linear interpolation between closest ranks
+func Percentile(prices []float64, p float64) float64 {
+	if len(prices) == 0 {
+		return math.NaN()
+	}
+	sorted := append([]float64(nil), prices...)
+	sort.Float64s(sorted)
+
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// Median returns the median of prices
+func Median(prices []float64) float64 {
+	return Percentile(prices, 50)
+}
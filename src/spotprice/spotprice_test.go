@@ -1,31 +1,65 @@
 package spotprice
 
 import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/koovee/thermia/clock"
 )
 
 func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestInit(t *testing.T) {
+func TestInitWithConfig(t *testing.T) {
 	s := State{}
 
-	if err := s.Init(); err == nil {
-		t.Errorf("init() should have failed, but it succeeded")
+	if err := s.InitWithConfig(Config{}); err == nil {
+		t.Errorf("InitWithConfig() without token should have failed, but it succeeded")
+	}
+
+	if err := s.InitWithConfig(Config{Token: "12345"}); err != nil {
+		t.Errorf("InitWithConfig() with token did not succeed: %s", err.Error())
+	}
+}
+
+func TestGetPrice(t *testing.T) {
+	s := State{M: &sync.Mutex{}}
+	s.HourPrice = make(map[string][]float64)
+	day := time.Date(2022, 12, 1, 0, 0, 0, 0, time.Local)
+	s.HourPrice[day.Format(DateLayout)] = []float64{10.0, 20.0, 30.0}
+
+	cases := map[string]struct {
+		hour          int
+		expectedPrice float64
+		expectedErr   bool
+	}{
+		"First hour":          {hour: 0, expectedPrice: 1.0},
+		"Last hour":           {hour: 2, expectedPrice: 3.0},
+		"Hour after the last": {hour: 3, expectedErr: true},
+		"Hour without price":  {hour: 23, expectedErr: true},
 	}
 
-	os.Setenv("TOKEN", "12345")
-	if err := s.Init(); err != nil {
-		t.Errorf("init() with TOKEN set did not succeed")
+	for k, tc := range cases {
+		price, err := s.GetPrice(day.Add(time.Duration(tc.hour) * time.Hour))
+		if (err != nil) != tc.expectedErr || price != tc.expectedPrice {
+			t.Fatalf("%s: GetPrice\ngot:  %v (%v)\nwant: %v (error: %v)\n", k, price, err, tc.expectedPrice, tc.expectedErr)
+		}
 	}
-	os.Unsetenv("TOKEN")
 }
 
 func TestCheapestHours(t *testing.T) {
-	s := State{}
+	s := State{M: &sync.Mutex{}, clock: clock.NewFake(time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC))}
 	s.HourPrice = make(map[string][]float64)
 	set1 := []float64{1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10.0, 11.0, 12.0, 13.0, 14.0, 15.0, 16.0, 17.0, 18.0, 19.0, 20.0, 21.0, 22.0, 23.0, 24.0}
 	set2 := []float64{-5.0, -4.0, -3.0, -2.0, -1.0, 0.0, 1.0, 2.0, 3.0, 4.0, 5.0, 6.0, 7.0, 8.0, 9.0, 10.0, 11.0, 12.0, 13.0, 14.0, 15.0}
@@ -49,10 +83,244 @@ func TestCheapestHours(t *testing.T) {
 	}
 
 	for k, tc := range cases {
-		s.HourPrice[time.Now().Format(DateLayout)] = tc.hourPrice
+		s.HourPrice["20221201"] = tc.hourPrice
 		result := IsCheapestHour(tc.hour, s.CheapestHours(tc.hours))
 		if result != tc.expectedResult {
 			t.Fatalf("%s: IsCheapestHour\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
 		}
 	}
 }
+
+func TestCheapestBlocks(t *testing.T) {
+	day := time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC)
+	s := State{M: &sync.Mutex{}, clock: clock.NewFake(day)}
+	set1 := []float64{5.0, 1.0, 9.0, 2.0, 2.0, 2.0, 9.0, 9.0, 1.0, 1.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 0.0, 0.0}
+	// 2 hour block at the start of the day is cheaper than 3 hour block, 3 hour block is at the end of the day
+	set2 := []float64{0.0, 0.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 1.0, 1.0, 1.0, 9.0}
+	// tomorrow's prices are the cheapest right after midnight
+	set3 := []float64{0.0, 0.0, 0.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0, 9.0}
+
+	cases := map[string]struct {
+		hourPrice      []float64
+		tomorrow       []float64
+		lengths        []int
+		expectedResult []int // hours from the start of the day
+	}{
+		"Set1: cheapest 1 hour block":           {hourPrice: set1, lengths: []int{1}, expectedResult: []int{22}},
+		"Set1: cheapest 3 hour block":           {hourPrice: set1, lengths: []int{3}, expectedResult: []int{3, 4, 5}},
+		"Set1: cheapest 2 and 2 hour blocks":    {hourPrice: set1, lengths: []int{2, 2}, expectedResult: []int{8, 9, 22, 23}},
+		"Set1: cheapest 3 and 2 hour blocks":    {hourPrice: set1, lengths: []int{3, 2}, expectedResult: []int{3, 4, 5, 22, 23}},
+		"Set1: block longer than a day":         {hourPrice: set1, lengths: []int{25}, expectedResult: nil},
+		"Set1: invalid block length":            {hourPrice: set1, lengths: []int{0}, expectedResult: nil},
+		"No prices: cheapest 1 hour block":      {hourPrice: nil, lengths: []int{1}, expectedResult: nil},
+		"Set1: whole day in two blocks (12+12)": {hourPrice: set1, lengths: []int{12, 12}, expectedResult: []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23}},
+		"Set2: shorter block first":             {hourPrice: set2, lengths: []int{3, 2}, expectedResult: []int{0, 1, 20, 21, 22}},
+		"Set2: same lengths in reverse order":   {hourPrice: set2, lengths: []int{2, 3}, expectedResult: []int{0, 1, 20, 21, 22}},
+		"Set1: block continues past midnight":   {hourPrice: set1, tomorrow: set3, lengths: []int{4}, expectedResult: []int{22, 23, 24, 25}},
+		"Set1: block starts on the day":         {hourPrice: set1, tomorrow: set3, lengths: []int{1}, expectedResult: []int{22}},
+		"Set1: longer than a day with tomorrow": {hourPrice: set1, tomorrow: set3, lengths: []int{25}, expectedResult: []int{2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26}},
+	}
+
+	midnight := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	for k, tc := range cases {
+		s.HourPrice = HourPrices{"20221201": tc.hourPrice, "20221202": tc.tomorrow}
+		var result []int
+		for _, hour := range s.CheapestBlocks(tc.lengths) {
+			result = append(result, int(hour.Sub(midnight)/time.Hour))
+		}
+		if !reflect.DeepEqual(result, tc.expectedResult) {
+			t.Fatalf("%s: CheapestBlocks\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+}
+
+func TestUpdateSpotPrices(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Fatalf("failed to load location: %s", err.Error())
+	}
+
+	// ENTSO-E returns prices of the requested day: day of month * 10 + hour (EUR/MWh). Prices of December 4th have 15
+	// minute resolution and only the first 12 hours are known.
+	var m sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		periodStart := r.URL.Query().Get("periodStart")
+		m.Lock()
+		requests = append(requests, periodStart)
+		m.Unlock()
+		start, err := time.Parse("200601021504", periodStart)
+		if err != nil || r.URL.Query().Get("securityToken") != "token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		day := start.In(loc)
+		resolution, perHour, hours := "PT60M", 1, 24
+		if day.Day() == 4 {
+			resolution, perHour, hours = "PT15M", 4, 12
+		}
+		var p strings.Builder
+		for position := 1; position <= perHour*hours; position++ {
+			hour := (position - 1) / perHour
+			fmt.Fprintf(&p, "<Point><position>%d</position><price.amount>%d</price.amount></Point>", position, day.Day()*10+hour)
+		}
+		fmt.Fprintf(w, `<Publication_MarketDocument><TimeSeries><mRID>1</mRID><Period><timeInterval><start>%s</start>
+<end>%s</end></timeInterval><resolution>%s</resolution>%s</Period></TimeSeries></Publication_MarketDocument>`,
+			start.Format("2006-01-02T15:04Z"), start.Add(time.Duration(hours)*time.Hour).Format("2006-01-02T15:04Z"), resolution, p.String())
+	}))
+	defer server.Close()
+
+	fake := clock.NewFake(time.Date(2022, 12, 1, 17, 30, 0, 0, loc))
+	s := State{}
+	if err = s.InitWithConfig(Config{Token: "token", URL: server.URL, Clock: fake}); err != nil {
+		t.Fatalf("InitWithConfig() did not succeed: %s", err.Error())
+	}
+
+	cases := []struct {
+		name             string
+		time             time.Time
+		expectedRequests []string
+		expectedDays     []string
+	}{
+		{name: "Today's prices at startup", time: time.Date(2022, 12, 1, 17, 30, 0, 0, loc), expectedRequests: []string{"202211302200"}, expectedDays: []string{"20221201"}},
+		{name: "No tomorrow's prices before 18:00", time: time.Date(2022, 12, 1, 17, 59, 0, 0, loc), expectedDays: []string{"20221201"}},
+		{name: "Tomorrow's prices from 18:00", time: time.Date(2022, 12, 1, 18, 0, 1, 0, loc), expectedRequests: []string{"202212012200"}, expectedDays: []string{"20221201", "20221202"}},
+		{name: "Tomorrow's prices are fetched once", time: time.Date(2022, 12, 1, 20, 0, 1, 0, loc), expectedDays: []string{"20221201", "20221202"}},
+		{name: "Day rollover uses fetched prices", time: time.Date(2022, 12, 2, 0, 0, 1, 0, loc), expectedDays: []string{"20221201", "20221202"}},
+		{name: "Yesterday's prices are removed", time: time.Date(2022, 12, 2, 19, 0, 1, 0, loc), expectedRequests: []string{"202212022200"}, expectedDays: []string{"20221202", "20221203"}},
+	}
+	for _, c := range cases {
+		fake.Set(c.time)
+		m.Lock()
+		requests = nil
+		m.Unlock()
+
+		s.UpdateSpotPrices(context.Background())
+
+		m.Lock()
+		if !reflect.DeepEqual(requests, c.expectedRequests) {
+			t.Errorf("%s: requests\ngot:  %v\nwant: %v\n", c.name, requests, c.expectedRequests)
+		}
+		m.Unlock()
+		for _, day := range c.expectedDays {
+			if len(s.HourPrice[day]) != 24 {
+				t.Errorf("%s: no prices for %s", c.name, day)
+			}
+		}
+		if len(s.HourPrice) != len(c.expectedDays) {
+			t.Errorf("%s: expected prices for %d days, got %d", c.name, len(c.expectedDays), len(s.HourPrice))
+		}
+	}
+
+	// prices of the current hour after the day rollover
+	fake.Set(time.Date(2022, 12, 3, 7, 0, 1, 0, loc))
+	if price, err := s.GetPrice(fake.Now()); err != nil || price != 3.7 {
+		t.Errorf("unexpected price: %v (%v)", price, err)
+	}
+	if cheapest := s.CheapestHours(1); !reflect.DeepEqual(cheapest, []int{0}) {
+		t.Errorf("unexpected cheapest hours: %v", cheapest)
+	}
+
+	// short series with 15 minute resolution
+	fake.Set(time.Date(2022, 12, 3, 18, 0, 1, 0, loc))
+	s.UpdateSpotPrices(context.Background())
+	if prices := s.HourPrice["20221204"]; len(prices) != 12 || prices[0] != 40 || prices[11] != 51 {
+		t.Errorf("unexpected prices of a short series: %v", prices)
+	}
+}
+
+func TestFetchTime(t *testing.T) {
+	var f fetchTime
+	if hours := f.hoursSince(); !math.IsNaN(hours) {
+		t.Fatalf("hours since fetch before the first fetch should be NaN, got %v", hours)
+	}
+	fake := clock.NewFake(time.Date(2022, 12, 1, 12, 0, 0, 0, time.UTC))
+	f.set(fake.Now(), fake)
+	fake.Advance(90 * time.Minute)
+	if hours := f.hoursSince(); hours != 1.5 {
+		t.Fatalf("hours since fetch\ngot:  %v\nwant: %v\n", hours, 1.5)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	set1 := []float64{4.0, 1.0, 3.0, 2.0, 5.0}
+	set2 := []float64{-2.0, 6.0, 2.0, 0.0}
+
+	cases := map[string]struct {
+		prices         []float64
+		percentile     float64
+		expectedResult float64
+	}{
+		"Set1: 0th percentile":   {prices: set1, percentile: 0, expectedResult: 1.0},
+		"Set1: 25th percentile":  {prices: set1, percentile: 25, expectedResult: 2.0},
+		"Set1: median":           {prices: set1, percentile: 50, expectedResult: 3.0},
+		"Set1: 90th percentile":  {prices: set1, percentile: 90, expectedResult: 4.6},
+		"Set1: 100th percentile": {prices: set1, percentile: 100, expectedResult: 5.0},
+		"Set2: median":           {prices: set2, percentile: 50, expectedResult: 1.0},
+	}
+
+	for k, tc := range cases {
+		result := Percentile(tc.prices, tc.percentile)
+		if math.Abs(result-tc.expectedResult) > 1e-9 {
+			t.Fatalf("%s: Percentile\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+
+	if !math.IsNaN(Median(nil)) {
+		t.Fatalf("Median of no prices should be NaN")
+	}
+}
+
+func TestFetchRange(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Helsinki")
+	if err != nil {
+		t.Fatalf("failed to load location: %s", err.Error())
+	}
+
+	// first day hourly (position 3 missing), second day with 15 minute resolution
+	var query url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		var hourly, quarterly strings.Builder
+		for position := 1; position <= 24; position++ {
+			if position != 3 {
+				fmt.Fprintf(&hourly, "<Point><position>%d</position><price.amount>%d</price.amount></Point>", position, position*10)
+			}
+		}
+		for position := 1; position <= 96; position++ {
+			fmt.Fprintf(&quarterly, "<Point><position>%d</position><price.amount>%d</price.amount></Point>", position, position)
+		}
+		fmt.Fprintf(w, `<Publication_MarketDocument><TimeSeries><mRID>1</mRID>
+<Period><timeInterval><start>2022-11-30T22:00Z</start><end>2022-12-01T22:00Z</end></timeInterval><resolution>PT60M</resolution>%s</Period>
+<Period><timeInterval><start>2022-12-01T22:00Z</start><end>2022-12-02T22:00Z</end></timeInterval><resolution>PT15M</resolution>%s</Period>
+</TimeSeries></Publication_MarketDocument>`, hourly.String(), quarterly.String())
+	}))
+	defer server.Close()
+
+	s := State{}
+	if err = s.InitWithConfig(Config{Token: "token", URL: server.URL}); err != nil {
+		t.Fatalf("InitWithConfig() did not succeed: %s", err.Error())
+	}
+	from := time.Date(2022, 12, 1, 0, 0, 0, 0, loc)
+	prices, err := s.FetchRange(context.Background(), from, from.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatalf("FetchRange() did not succeed: %s", err.Error())
+	}
+	if query.Get("periodStart") != "202211302200" || query.Get("periodEnd") != "202212022200" || query.Get("documentType") != "A44" {
+		t.Errorf("unexpected query: %v", query)
+	}
+	if len(prices) != 48 {
+		t.Fatalf("expected 48 hours, got %d", len(prices))
+	}
+	for i, e := range map[int]Price{
+		0:  {Time: from, Price: 10},
+		2:  {Time: from.Add(2 * time.Hour), Price: 20}, // missing position repeats previous price
+		23: {Time: from.Add(23 * time.Hour), Price: 240},
+		24: {Time: from.AddDate(0, 0, 1), Price: 2.5}, // average of quarters 1-4
+		47: {Time: from.AddDate(0, 0, 1).Add(23 * time.Hour), Price: 94.5},
+	} {
+		if !prices[i].Time.Equal(e.Time) || prices[i].Price != e.Price {
+			t.Errorf("hour %d: expected %v, got %v", i, e, prices[i])
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+
+	"github.com/koovee/thermia/config"
+	"github.com/koovee/thermia/control"
+	"github.com/koovee/thermia/logging"
+	"github.com/koovee/thermia/spotprice"
+)
+
+// decision is the desired operating mode for an hour and the reason for it
+type decision struct {
+	mode            control.Mode
+	boost           bool
+	reason          string
+	frostProtection bool
+	strategy        string    // strategy that made the decision
+	profile         string    // strategy profile (empty for the main strategy)
+	until           time.Time // expiry of the manual override (zero if the decision is not an override)
+	constraints     []string  // constraints that changed the decision of the strategy (e.g. forced window)
+}
+
+// decide returns the desired operating mode for a given time based on configuration and hourly price. Active manual
+// override is used instead of any strategy (frost protection still applies). Strategy of
+// the calendar profile replaces the main strategy during calendar periods. Schedule is used as a fallback when the
+// strategy fails (e.g. pricing is not available). Forced windows, indoor temperature limits and always on price are
+// applied on top of the strategy and frost protection on top of everything.
+func (s state) decide(now time.Time) (d decision) {
+	var err error
+
+	if d, ok := s.applyOverride(now); ok {
+		d = constrain(d, "frost protection", s.applyFrostProtection(d))
+		d.strategy = "manual override"
+		return d
+	}
+
+	p, profileActive := s.activeProfile(now)
+	if profileActive {
+		s = s.withProfile(p)
+	}
+
+	var strategy string
+	if len(s.blockHours) > 0 {
+		strategy = fmt.Sprintf("cheapest blocks %v", s.blockHours)
+		d, err = s.decideBasedOnCheapestBlocks(now)
+	} else if s.relative.mode != "" {
+		strategy = fmt.Sprintf("relative threshold (%s %.2f)", s.relative.mode, s.relative.value)
+		d, err = s.decideBasedOnRelativeThreshold(now)
+	} else if s.activeHours > 0 && s.threshold > 0 {
+		strategy = fmt.Sprintf("threshold (%.2f) and active hours (%d)", s.threshold, s.activeHours)
+		d, err = s.decideBasedOnThresholdAndActiveHours(now)
+	} else if s.activeHours > 0 {
+		strategy = fmt.Sprintf("active hours (%d)", s.activeHours)
+		d, err = s.decideBasedOnActiveHours(now)
+	} else if s.threshold > 0 {
+		strategy = fmt.Sprintf("threshold (%.2f)", s.threshold)
+		d, err = s.decideBasedOnThreshold(now)
+	} else {
+		strategy = "schedule"
+		d = s.decideBasedOnSchedule(now)
+	}
+	if err != nil {
+		if !s.quiet {
+			fallbackCounter.Inc()
+		}
+		strategy = "schedule (fallback)"
+		d = s.decideBasedOnSchedule(now)
+	}
+	if profileActive {
+		d = constrain(d, "profile", s.applyProfile(p, d))
+	}
+
+	d = constrain(d, "forced window", s.applyForcedWindows(now, d))
+	d = constrain(d, "indoor maximum", s.applyIndoorMaximum(d))
+	d = constrain(d, "always on price", s.applyAlwaysOnPrice(now, d))
+	d = constrain(d, "indoor minimum", s.applyIndoorMinimum(d))
+	d = constrain(d, "frost protection", s.applyFrostProtection(d))
+	d.strategy = strategy
+	if profileActive {
+		d.profile = p.name
+	}
+	return d
+}
+
+// constrain returns the decision c made by a constraint from the decision d. Name of the constraint is recorded if it
+// changed the decision.
+func constrain(d decision, name string, c decision) decision {
+	c.constraints = d.constraints
+	if c.mode != d.mode || c.boost != d.boost || c.reason != d.reason {
+		c.constraints = append(d.constraints[:len(d.constraints):len(d.constraints)], name)
+	}
+	return c
+}
+
+// apply sets the relays according to the decision
+func (s state) apply(ctx context.Context, d decision) (err error) {
+	heating := "OFF"
+	if d.mode == control.Normal {
+		heating = "ON"
+	}
+	log.Info("heating "+heating, "mode", d.mode, "boost", d.boost, "strategy", d.strategy, "profile", d.profile, "reason", d.reason)
+
+	err = s.cs.Set(ctx, d.mode)
+	if err != nil {
+		log.Error("failed to set heat pump mode", "mode", d.mode, "error", err)
+		return err
+	}
+
+	err = s.cs.SetBoost(ctx, d.boost)
+	if err != nil {
+		log.Error("failed to set boost", "boost", d.boost, "error", err)
+		return err
+	}
+	return nil
+}
+
+// logger returns the logger for decisions. Messages are discarded when the state is quiet (e.g. when planning the
+// upcoming hours).
+func (s state) logger() *slog.Logger {
+	if s.quiet {
+		return logging.Discard
+	}
+	return log
+}
+
+// logStatus logs the current status of the controller
+func (s state) logStatus(d decision) {
+	attrs := []any{"mode", d.mode, "boost", d.boost, "frostProtection", d.frostProtection, "activeHours", s.activeHours}
+	if s.outdoor.known {
+		attrs = append(attrs, "outdoor", s.outdoor.current)
+	}
+	if s.indoor.known {
+		attrs = append(attrs, "indoor", s.indoor.temperature)
+	}
+	profile := d.profile
+	if profile == "" {
+		profile = "default"
+	}
+	log.Info("status", append(attrs, "profile", profile)...)
+}
+
+// applyForcedWindows forces NORMAL mode during forced on windows and ROOM LOWERING mode during forced off windows
+func (s state) applyForcedWindows(now time.Time, d decision) decision {
+	if s.forcedOn.Contains(now) {
+		return decision{mode: control.Normal, boost: d.boost, reason: "forced on window"}
+	}
+	if s.forcedOff.Contains(now) {
+		return decision{mode: control.Lowered, reason: "forced off window"}
+	}
+	return d
+}
+
+// applyAlwaysOnPrice forces NORMAL mode when price is lower than the always on price. It overrides all strategies.
+func (s state) applyAlwaysOnPrice(now time.Time, d decision) decision {
+	if !s.alwaysOn.enabled {
+		return d
+	}
+	price, err := s.sp.GetPrice(now)
+	if err != nil || price >= s.alwaysOn.price {
+		return d
+	}
+	return decision{
+		mode:   control.Normal,
+		boost:  s.alwaysOn.boost,
+		reason: fmt.Sprintf("price lower than the always on price: %0.2f (always on price: %0.2f)", price, s.alwaysOn.price),
+	}
+}
+
+// decideBasedOnThreshold decides heating based on threshold
+func (s state) decideBasedOnThreshold(now time.Time) (d decision, err error) {
+	price, err := s.sp.GetPrice(now)
+	if err != nil {
+		s.logger().Warn("failed to control based on threshold", "error", err)
+		return d, err
+	}
+
+	s.logger().Debug("control based on threshold", "threshold", s.threshold, "time", now, "price", price)
+
+	if price <= s.threshold {
+		// heating ON / NORMAL mode (price is lower than the threshold)
+		return decision{mode: control.Normal, reason: fmt.Sprintf("price lower than the threshold: %0.2f (threshold: %0.2f)", price, s.threshold)}, nil
+	}
+	// heating OFF / ROOM LOWERING mode (unless pre-heating before a price spike)
+	d = decision{mode: control.Lowered, reason: fmt.Sprintf("price higher than the threshold: %0.2f (threshold: %0.2f)", price, s.threshold)}
+	return s.applyPreheat(now, price, d), nil
+}
+
+// decideBasedOnActiveHours decides heating based on activeHours (and maxPrice if set)
+func (s state) decideBasedOnActiveHours(now time.Time) (d decision, err error) {
+	price, err := s.sp.GetPrice(now)
+	if err != nil {
+		s.logger().Warn("failed to control based on active hours", "error", err)
+		return d, err
+	}
+
+	s.logger().Debug("control based on active hours", "activeHours", s.activeHours, "time", now, "price", price)
+
+	return s.decideCheapestHour(now, price, fmt.Sprintf("this is one of the %d cheapest hours", s.activeHours)), nil
+}
+
+// decideBasedOnThresholdAndActiveHours decides heating based on threshold and activeHours (and maxPrice if set)
+func (s state) decideBasedOnThresholdAndActiveHours(now time.Time) (d decision, err error) {
+	price, err := s.sp.GetPrice(now)
+	if err != nil {
+		s.logger().Warn("failed to control based on threshold and active hours", "error", err)
+		return d, err
+	}
+
+	s.logger().Debug("control based on threshold and active hours", "threshold", s.threshold, "activeHours", s.activeHours, "time", now, "price", price)
+
+	if price <= s.threshold {
+		// heating ON / NORMAL mode (price is lower than the threshold)
+		return decision{mode: control.Normal, reason: fmt.Sprintf("price lower than the threshold: %0.2f (threshold: %0.2f)", price, s.threshold)}, nil
+	}
+	// price is higher than the threshold (pre-heat before a price spike if this is not one of the cheapest hours)
+	d = s.decideCheapestHour(now, price, fmt.Sprintf("price higher than threshold but this is one of the %d cheapest hours", s.activeHours))
+	return s.applyPreheat(now, price, d), nil
+}
+
+// decideCheapestHour decides heating based on whether the hour is one of the activeHours cheapest hours
+func (s state) decideCheapestHour(now time.Time, price float64, reason string) decision {
+	if !spotprice.IsCheapestHour(now.Hour(), s.sp.CheapestHoursOn(now, s.activeHours)) {
+		// heating OFF / ROOM LOWERING mode
+		return decision{mode: control.Lowered, reason: fmt.Sprintf("this is not one of the %d cheapest hours", s.activeHours)}
+	}
+	// This is one of the cheapest hours
+	if s.maxPrice > 0 && price > s.maxPrice {
+		return decision{mode: control.Lowered, reason: fmt.Sprintf("this is one of the %d cheapest hours: %0.2f, but price is higher than maxPrice (%0.2f)", s.activeHours, price, s.maxPrice)}
+	}
+	// Heating ON / NORMAL mode (this is one of the cheapest hours)
+	return decision{mode: control.Normal, reason: fmt.Sprintf("%s: %0.2f", reason, price)}
+}
+
+// decideBasedOnCheapestBlocks decides heating based on the cheapest contiguous blocks (and maxPrice if set)
+func (s state) decideBasedOnCheapestBlocks(now time.Time) (d decision, err error) {
+	price, err := s.sp.GetPrice(now)
+	if err != nil {
+		s.logger().Warn("failed to control based on cheapest blocks", "error", err)
+		return d, err
+	}
+
+	blocks := s.sp.CheapestBlocksOn(now, s.blockHours)
+	if blocks == nil {
+		s.logger().Warn("failed to control based on cheapest blocks: blocks do not fit into available prices", "blockHours", s.blockHours)
+		return d, errors.New("no cheapest blocks available")
+	}
+	// block of the previous day may continue past midnight
+	blocks = append(blocks, s.sp.CheapestBlocksOn(now.AddDate(0, 0, -1), s.blockHours)...)
+
+	s.logger().Debug("control based on cheapest blocks", "blockHours", s.blockHours, "time", now, "price", price)
+
+	if !spotprice.IsCheapestTime(now, blocks) {
+		// heating OFF / ROOM LOWERING mode
+		return decision{mode: control.Lowered, reason: fmt.Sprintf("this hour is not in any of the cheapest blocks %v", s.blockHours)}, nil
+	}
+	// This hour belongs to one of the cheapest blocks
+	if s.maxPrice > 0 && price > s.maxPrice {
+		return decision{mode: control.Lowered, reason: fmt.Sprintf("this hour is in one of the cheapest blocks %v: %0.2f, but price is higher than maxPrice (%0.2f)", s.blockHours, price, s.maxPrice)}, nil
+	}
+	// Heating ON / NORMAL mode
+	return decision{mode: control.Normal, reason: fmt.Sprintf("this hour is in one of the cheapest blocks %v: %0.2f", s.blockHours, price)}, nil
+}
+
+// decideBasedOnRelativeThreshold decides heating based on a threshold derived from the price distribution
+func (s state) decideBasedOnRelativeThreshold(now time.Time) (d decision, err error) {
+	price, err := s.sp.GetPrice(now)
+	if err != nil {
+		s.logger().Warn("failed to control based on relative threshold", "error", err)
+		return d, err
+	}
+
+	var prices []float64
+	if s.relative.window == config.WindowRolling {
+		prices = s.sp.PricesFrom(now, 24)
+	} else {
+		prices = s.sp.DayPrices(now)
+	}
+	if len(prices) == 0 {
+		s.logger().Warn("failed to control based on relative threshold: no price distribution available")
+		return d, errors.New("no price information available")
+	}
+
+	var threshold float64
+	if s.relative.mode == relativePercentile {
+		threshold = spotprice.Percentile(prices, s.relative.value)
+	} else {
+		median := spotprice.Median(prices)
+		threshold = median + math.Abs(median)*s.relative.value/100
+	}
+
+	s.logger().Debug("control based on relative threshold", "relative", s.relative.mode, "value", s.relative.value, "window", s.relative.window,
+		"floor", s.relative.floor, "threshold", threshold, "time", now, "price", price)
+
+	if price < s.relative.floor || price <= threshold {
+		// heating ON / NORMAL mode (price is lower than the floor or the relative threshold)
+		return decision{mode: control.Normal, reason: fmt.Sprintf("price lower than the floor or relative threshold: %0.2f (floor: %0.2f, threshold: %0.2f)", price, s.relative.floor, threshold)}, nil
+	}
+	// heating OFF / ROOM LOWERING mode
+	return decision{mode: control.Lowered, reason: fmt.Sprintf("price higher than the relative threshold: %0.2f (threshold: %0.2f)", price, threshold)}, nil
+}
+
+// decideBasedOnSchedule decides heating based on schedule
+func (s state) decideBasedOnSchedule(now time.Time) decision {
+	price, _ := s.sp.GetPrice(now)
+
+	s.logger().Debug("control based on schedule", "time", now)
+
+	if s.schedule.Contains(now) {
+		// Heating ON / NORMAL mode
+		return decision{mode: control.Normal, reason: fmt.Sprintf("schedule (price: %0.2f)", price)}
+	}
+	// heating OFF / ROOM LOWERING mode
+	return decision{mode: control.Lowered, reason: fmt.Sprintf("schedule (price: %0.2f)", price)}
+}
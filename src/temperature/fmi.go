@@ -0,0 +1,135 @@
+package temperature
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+const (
+	fmiApiUrl                = "https://opendata.fmi.fi/wfs"
+	fmiObservationsQuery     = "fmi::observations::weather::simple"
+	fmiForecastQuery         = "fmi::forecast::harmonie::surface::point::simple"
+	fmiObservationsParameter = "t2m"
+	fmiForecastParameter     = "temperature"
+)
+
+// FMISource reads temperature observations and forecast from FMI open data (WFS simple feature format)
+type FMISource struct {
+	latest
+	url   string
+	place string
+	hc    http.Client
+}
+
+type fmiResponse struct {
+	XMLName xml.Name `xml:"FeatureCollection"`
+	Members []struct {
+		Time  string `xml:"BsWfsElement>Time"`
+		Name  string `xml:"BsWfsElement>ParameterName"`
+		Value string `xml:"BsWfsElement>ParameterValue"`
+	} `xml:"member"`
+}
+
+// NewFMISource returns a temperature source for a given place (e.g. "helsinki"). Empty url uses FMI open data API.
+// Observations older than maxAge are not used (zero disables the check). Age of the observations is measured with clk.
+func NewFMISource(url, place string, maxAge time.Duration, clk clock.Clock) *FMISource {
+	if url == "" {
+		url = fmiApiUrl
+	}
+	return &FMISource{
+		latest: newLatest(maxAge, clk),
+		url:    url,
+		place:  place,
+		hc:     http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Current returns the latest observed temperature. The latest observation is returned when the request fails, until
+// it is older than maxAge.
+func (s *FMISource) Current(ctx context.Context) (float64, error) {
+	times, values, err := s.get(ctx, fmiObservationsQuery, fmiObservationsParameter)
+	if err == nil && len(values) == 0 {
+		err = errors.New("no temperature observations available")
+	}
+	if err == nil {
+		s.set(values[len(values)-1], times[len(times)-1])
+	}
+	return s.current(s.place, err)
+}
+
+// Forecast returns hourly forecast for the next n hours
+func (s *FMISource) Forecast(ctx context.Context, n int) ([]float64, error) {
+	_, values, err := s.get(ctx, fmiForecastQuery, fmiForecastParameter)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) > n {
+		return values[:n], nil
+	}
+	return values, nil
+}
+
+// get returns times and values of a parameter in time order, missing values (NaN) are skipped
+func (s *FMISource) get(ctx context.Context, query, parameter string) (times []time.Time, values []float64, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	q := url.Values{}
+	q.Add("service", "WFS")
+	q.Add("version", "2.0.0")
+	q.Add("request", "getFeature")
+	q.Add("storedquery_id", query)
+	q.Add("place", s.place)
+	q.Add("parameters", parameter)
+	q.Add("timestep", "60")
+	req.URL.RawQuery = q.Encode()
+
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		log.Debug("failed to make http request", "source", "fmi", "error", err)
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	response := fmiResponse{}
+	if err = xml.Unmarshal(body, &response); err != nil {
+		log.Debug("failed to unmarshal xml", "source", "fmi", "error", err)
+		return nil, nil, err
+	}
+
+	for _, m := range response.Members {
+		if m.Name != parameter {
+			continue
+		}
+		value, err := strconv.ParseFloat(m.Value, 64)
+		if err != nil || math.IsNaN(value) {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, m.Time)
+		if err != nil {
+			continue
+		}
+		times = append(times, t)
+		values = append(values, value)
+	}
+	return times, values, nil
+}
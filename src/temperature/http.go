@@ -0,0 +1,93 @@
+package temperature
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+// HTTPSource reads temperature from a JSON endpoint, e.g. {"temperature": -5.2, "forecast": [-5.5, -6.0]}. Optional
+// time tells when the temperature was measured, e.g. {"temperature": 21.5, "time": "2024-01-15T12:00:00Z"}.
+type HTTPSource struct {
+	latest
+	url string
+	hc  http.Client
+}
+
+type httpResponse struct {
+	Temperature *float64   `json:"temperature"`
+	Time        *time.Time `json:"time"`
+	Forecast    []float64  `json:"forecast"`
+}
+
+// NewHTTPSource returns a temperature source for a JSON endpoint. Readings older than maxAge are not used (zero
+// disables the check). Age of the readings is measured with clk.
+func NewHTTPSource(url string, maxAge time.Duration, clk clock.Clock) *HTTPSource {
+	return &HTTPSource{
+		latest: newLatest(maxAge, clk),
+		url:    url,
+		hc:     http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Current returns the current temperature. The latest temperature is returned when the request fails, until it is
+// older than maxAge.
+func (s *HTTPSource) Current(ctx context.Context) (float64, error) {
+	response, err := s.get(ctx)
+	if err == nil && response.Temperature == nil {
+		err = errors.New("temperature missing from response")
+	}
+	if err == nil {
+		t := s.clock.Now()
+		if response.Time != nil {
+			t = *response.Time
+		}
+		s.set(*response.Temperature, t)
+	}
+	return s.current(s.url, err)
+}
+
+// Forecast returns hourly forecast for the next n hours
+func (s *HTTPSource) Forecast(ctx context.Context, n int) ([]float64, error) {
+	response, err := s.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Forecast) > n {
+		return response.Forecast[:n], nil
+	}
+	return response.Forecast, nil
+}
+
+func (s *HTTPSource) get(ctx context.Context) (response httpResponse, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return response, err
+	}
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		log.Debug("failed to make http request", "source", "http", "url", s.url, "error", err)
+		return response, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return response, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return response, err
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		log.Debug("failed to unmarshal JSON", "source", "http", "url", s.url, "error", err)
+		return response, errors.New("failed to unmarshal JSON response")
+	}
+	return response, nil
+}
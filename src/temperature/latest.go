@@ -0,0 +1,73 @@
+package temperature
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+// latest is the latest temperature reading of a source. The latest reading is used when reading the source fails
+// (e.g. a battery powered sensor is sleeping) until it is older than maxAge (zero disables the check).
+type latest struct {
+	maxAge      time.Duration
+	clock       clock.Clock
+	m           sync.Mutex
+	temperature float64
+	time        time.Time       // time of the reading, zero before the first reading
+	received    func(t float64) // called with every new reading (optional)
+}
+
+func newLatest(maxAge time.Duration, clk clock.Clock) latest {
+	if clk == nil {
+		clk = clock.Real
+	}
+	return latest{maxAge: maxAge, clock: clk}
+}
+
+// set stores a reading made at a given time unless a newer reading is already known
+func (l *latest) set(temperature float64, t time.Time) {
+	l.m.Lock()
+	if t.Before(l.time) {
+		l.m.Unlock()
+		return
+	}
+	l.temperature, l.time = temperature, t
+	received := l.received
+	l.m.Unlock()
+
+	if received != nil {
+		received(temperature)
+	}
+}
+
+// notify sets the function called with every new reading
+func (l *latest) notify(f func(t float64)) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	l.received = f
+}
+
+// current returns the latest temperature of a source (name is used in errors). readErr is the error of reading the
+// source (if any), it is returned when there is no recent enough reading.
+func (l *latest) current(name string, readErr error) (float64, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if l.time.IsZero() {
+		if readErr != nil {
+			return 0, readErr
+		}
+		return 0, fmt.Errorf("no temperature received from %s", name)
+	}
+	if l.maxAge > 0 && l.clock.Now().Sub(l.time) > l.maxAge {
+		if readErr != nil {
+			return 0, fmt.Errorf("temperature from %s is too old (received: %s): %w", name, l.time.Format(time.RFC822), readErr)
+		}
+		return 0, fmt.Errorf("temperature from %s is too old (received: %s)", name, l.time.Format(time.RFC822))
+	}
+	if readErr != nil {
+		log.Debug("failed to read temperature, using the latest reading", "source", name, "received", l.time, "error", readErr)
+	}
+	return l.temperature, nil
+}
@@ -0,0 +1,89 @@
+package temperature
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/koovee/thermia/clock"
+)
+
+// MQTTSource reads temperature from MQTT topic. Payload is either a plain number or JSON object with temperature
+// field, e.g. {"temperature": 21.5}.
+type MQTTSource struct {
+	latest
+	client mqtt.Client
+	topic  string
+}
+
+// NewMQTTSource connects to MQTT broker (e.g. tcp://10.0.0.2:1883) and subscribes to topic. Readings older than
+// maxAge are not used (zero disables the check). Age of the readings is measured with clk.
+func NewMQTTSource(broker, topic string, maxAge time.Duration, clk clock.Clock) (*MQTTSource, error) {
+	s := &MQTTSource{latest: newLatest(maxAge, clk), topic: topic}
+
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(fmt.Sprintf("thermia-%d", time.Now().UnixNano())).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// (re)subscribe on every connect
+			token := c.Subscribe(topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+				s.handle(msg.Payload())
+			})
+			if token.Wait() && token.Error() != nil {
+				log.Error("failed to subscribe to MQTT topic", "topic", topic, "error", token.Error())
+			}
+		})
+
+	s.client = mqtt.NewClient(opts)
+	token := s.client.Connect()
+	if token.WaitTimeout(10*time.Second) && token.Error() != nil {
+		return nil, token.Error()
+	}
+	return s, nil
+}
+
+// Close disconnects from the MQTT broker
+func (s *MQTTSource) Close() error {
+	s.client.Disconnect(250)
+	return nil
+}
+
+// Notify sets f to be called with every temperature received from the topic
+func (s *MQTTSource) Notify(f func(t float64)) {
+	s.notify(f)
+}
+
+// Current returns the latest temperature received from the topic
+func (s *MQTTSource) Current(context.Context) (float64, error) {
+	return s.current(s.topic, nil)
+}
+
+func (s *MQTTSource) handle(payload []byte) {
+	t, err := parsePayload(payload)
+	if err != nil {
+		log.Warn("failed to parse temperature from MQTT topic", "topic", s.topic, "error", err)
+		return
+	}
+	s.set(t, s.clock.Now())
+}
+
+func parsePayload(payload []byte) (float64, error) {
+	str := strings.TrimSpace(string(payload))
+	if t, err := strconv.ParseFloat(str, 64); err == nil {
+		return t, nil
+	}
+	var response httpResponse
+	if err := json.Unmarshal([]byte(str), &response); err != nil {
+		return 0, err
+	}
+	if response.Temperature == nil {
+		return 0, errors.New("temperature missing from payload")
+	}
+	return *response.Temperature, nil
+}
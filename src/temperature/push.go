@@ -0,0 +1,36 @@
+package temperature
+
+import (
+	"context"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+// PushSource receives temperature pushed to the controller, e.g. by Shelly H&T "report sensor values" action URL
+// (http://thermia.local:8080/api/temperature, the H&T adds ?hum=45&temp=21.5&id=shellyht-XXXX). Battery powered H&T
+// sleeps between the measurements, so it reports the temperature more reliably than it answers to polling.
+type PushSource struct {
+	latest
+}
+
+// NewPushSource returns a temperature source for pushed temperatures. Temperatures older than maxAge are not used
+// (zero disables the check). Age of the temperatures is measured with clk.
+func NewPushSource(maxAge time.Duration, clk clock.Clock) *PushSource {
+	return &PushSource{latest: newLatest(maxAge, clk)}
+}
+
+// Push stores a temperature received now
+func (s *PushSource) Push(t float64) {
+	s.set(t, s.clock.Now())
+}
+
+// Notify sets f to be called with every pushed temperature
+func (s *PushSource) Notify(f func(t float64)) {
+	s.notify(f)
+}
+
+// Current returns the latest pushed temperature
+func (s *PushSource) Current(context.Context) (float64, error) {
+	return s.current("push", nil)
+}
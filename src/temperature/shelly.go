@@ -0,0 +1,83 @@
+package temperature
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+// ShellyHTSource reads temperature from Shelly H&T status API (e.g. http://10.0.0.85/status). Battery powered H&T
+// sleeps between the measurements and answers only when it is awake (or when it is powered by USB), so the latest
+// temperature is used until it is older than maxAge. PushSource receives the temperature from the H&T instead.
+type ShellyHTSource struct {
+	latest
+	url string
+	hc  http.Client
+}
+
+type shellyHTStatusResponse struct {
+	Tmp struct {
+		Value   float64 `json:"value"`
+		Units   string  `json:"units"`
+		TC      float64 `json:"tC"`
+		IsValid bool    `json:"is_valid"`
+	} `json:"tmp"`
+}
+
+// NewShellyHTSource returns a temperature source for Shelly H&T status API. Readings older than maxAge are not used
+// (zero disables the check). Age of the readings is measured with clk.
+func NewShellyHTSource(url string, maxAge time.Duration, clk clock.Clock) *ShellyHTSource {
+	return &ShellyHTSource{
+		latest: newLatest(maxAge, clk),
+		url:    url,
+		hc:     http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Current returns the current temperature. The latest temperature is returned when the H&T does not answer, until it
+// is older than maxAge.
+func (s *ShellyHTSource) Current(ctx context.Context) (float64, error) {
+	t, err := s.read(ctx)
+	if err == nil {
+		s.set(t, s.clock.Now())
+	}
+	return s.current(s.url, err)
+}
+
+func (s *ShellyHTSource) read(ctx context.Context) (float64, error) {
+	var response shellyHTStatusResponse
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := s.hc.Do(req)
+	if err != nil {
+		log.Debug("failed to make http request", "source", "shelly", "url", s.url, "error", err)
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		log.Debug("failed to unmarshal JSON", "source", "shelly", "url", s.url, "error", err)
+		return 0, errors.New("failed to unmarshal JSON response")
+	}
+	if !response.Tmp.IsValid {
+		return 0, errors.New("temperature reading is not valid")
+	}
+	return response.Tmp.TC, nil
+}
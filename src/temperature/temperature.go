@@ -0,0 +1,116 @@
+package temperature
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/koovee/thermia/logging"
+)
+
+var log = logging.Component("temperature")
+
+// Source provides temperature readings
+type Source interface {
+	// Current returns the current temperature (°C)
+	Current(ctx context.Context) (float64, error)
+}
+
+// Notifier is a source that receives temperatures in the background (pushed or subscribed) instead of reading them
+// when asked
+type Notifier interface {
+	// Notify sets f to be called with every new temperature. f is called from the goroutine that receives the
+	// temperature, so it must not block.
+	Notify(f func(t float64))
+}
+
+// Forecaster provides temperature forecast
+type Forecaster interface {
+	// Forecast returns hourly temperatures (°C) for the next n hours
+	Forecast(ctx context.Context, n int) ([]float64, error)
+}
+
+// Point maps temperature (°C) to the number of active hours
+type Point struct {
+	Temperature float64
+	Hours       int
+}
+
+// Curve is a heating curve which maps outdoor temperature to the number of active hours. Points are sorted by
+// temperature. Active hours are interpolated linearly between the points and clamped outside them.
+type Curve []Point
+
+// ParseCurve parses heating curve from comma separated temperature:hours pairs, e.g. "-25:20,5:8"
+func ParseCurve(str string) (c Curve, err error) {
+	for _, pair := range strings.Split(str, ",") {
+		fields := strings.Split(strings.TrimSpace(pair), ":")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("invalid heating curve point: %q", pair)
+		}
+		var p Point
+		p.Temperature, err = strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid heating curve temperature: %q", fields[0])
+		}
+		p.Hours, err = strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid heating curve hours: %q", fields[1])
+		}
+		if p.Hours < 0 || p.Hours > 24 {
+			return nil, fmt.Errorf("invalid heating curve hours: %d", p.Hours)
+		}
+		c = append(c, p)
+	}
+	if len(c) == 0 {
+		return nil, errors.New("empty heating curve")
+	}
+	sort.Slice(c, func(i, j int) bool { return c[i].Temperature < c[j].Temperature })
+	return c, nil
+}
+
+// ActiveHours returns the number of active hours for a given temperature (rounded up)
+func (c Curve) ActiveHours(temperature float64) int {
+	if len(c) == 0 {
+		return 0
+	}
+	if temperature <= c[0].Temperature {
+		return c[0].Hours
+	}
+	for i := 1; i < len(c); i++ {
+		if temperature <= c[i].Temperature {
+			a, b := c[i-1], c[i]
+			hours := float64(a.Hours) + (float64(b.Hours)-float64(a.Hours))*(temperature-a.Temperature)/(b.Temperature-a.Temperature)
+			return int(math.Ceil(hours - 1e-9))
+		}
+	}
+	return c[len(c)-1].Hours
+}
+
+// Effective returns the temperature used for the heating curve: the average of the current temperature and the
+// forecast for the next n hours. Forecast is ignored if source does not provide it or it is not available.
+func Effective(ctx context.Context, source Source, n int) (float64, error) {
+	current, err := source.Current(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	forecaster, ok := source.(Forecaster)
+	if !ok || n <= 0 {
+		return current, nil
+	}
+	forecast, err := forecaster.Forecast(ctx, n)
+	if err != nil || len(forecast) == 0 {
+		log.Info("temperature forecast not available, using current temperature")
+		return current, nil
+	}
+
+	sum := current
+	for _, t := range forecast {
+		sum += t
+	}
+	return sum / float64(len(forecast)+1), nil
+}
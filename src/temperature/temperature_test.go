@@ -0,0 +1,275 @@
+package temperature
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/koovee/thermia/clock"
+)
+
+const fmiObservations = `<?xml version="1.0" encoding="UTF-8"?>
+<wfs:FeatureCollection xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:BsWfs="http://xml.fmi.fi/schema/wfs/2.0">
+  <wfs:member>
+    <BsWfs:BsWfsElement>
+      <BsWfs:Time>2022-10-28T10:00:00Z</BsWfs:Time>
+      <BsWfs:ParameterName>t2m</BsWfs:ParameterName>
+      <BsWfs:ParameterValue>-3.5</BsWfs:ParameterValue>
+    </BsWfs:BsWfsElement>
+  </wfs:member>
+  <wfs:member>
+    <BsWfs:BsWfsElement>
+      <BsWfs:Time>2022-10-28T11:00:00Z</BsWfs:Time>
+      <BsWfs:ParameterName>t2m</BsWfs:ParameterName>
+      <BsWfs:ParameterValue>-4.0</BsWfs:ParameterValue>
+    </BsWfs:BsWfsElement>
+  </wfs:member>
+  <wfs:member>
+    <BsWfs:BsWfsElement>
+      <BsWfs:Time>2022-10-28T12:00:00Z</BsWfs:Time>
+      <BsWfs:ParameterName>t2m</BsWfs:ParameterName>
+      <BsWfs:ParameterValue>NaN</BsWfs:ParameterValue>
+    </BsWfs:BsWfsElement>
+  </wfs:member>
+</wfs:FeatureCollection>`
+
+const fmiForecast = `<?xml version="1.0" encoding="UTF-8"?>
+<wfs:FeatureCollection xmlns:wfs="http://www.opengis.net/wfs/2.0" xmlns:BsWfs="http://xml.fmi.fi/schema/wfs/2.0">
+  <wfs:member>
+    <BsWfs:BsWfsElement>
+      <BsWfs:Time>2022-10-28T13:00:00Z</BsWfs:Time>
+      <BsWfs:ParameterName>temperature</BsWfs:ParameterName>
+      <BsWfs:ParameterValue>-6.0</BsWfs:ParameterValue>
+    </BsWfs:BsWfsElement>
+  </wfs:member>
+  <wfs:member>
+    <BsWfs:BsWfsElement>
+      <BsWfs:Time>2022-10-28T14:00:00Z</BsWfs:Time>
+      <BsWfs:ParameterName>temperature</BsWfs:ParameterName>
+      <BsWfs:ParameterValue>-8.0</BsWfs:ParameterValue>
+    </BsWfs:BsWfsElement>
+  </wfs:member>
+</wfs:FeatureCollection>`
+
+func TestMain(m *testing.M) {
+	os.Exit(m.Run())
+}
+
+func TestCurve(t *testing.T) {
+	if _, err := ParseCurve("-25:20,invalid"); err == nil {
+		t.Errorf("ParseCurve() should have failed, but it succeeded")
+	}
+	if _, err := ParseCurve("-25:25"); err == nil {
+		t.Errorf("ParseCurve() should have failed, but it succeeded")
+	}
+
+	c, err := ParseCurve("5:8, -25:20")
+	if err != nil {
+		t.Fatalf("ParseCurve() did not succeed: %s", err.Error())
+	}
+
+	cases := map[string]struct {
+		temperature    float64
+		expectedResult int
+	}{
+		"Colder than the curve": {temperature: -30, expectedResult: 20},
+		"Coldest point":         {temperature: -25, expectedResult: 20},
+		"Between points":        {temperature: -10, expectedResult: 14},
+		"Between points (ceil)": {temperature: -9, expectedResult: 14},
+		"Warmest point":         {temperature: 5, expectedResult: 8},
+		"Warmer than the curve": {temperature: 20, expectedResult: 8},
+	}
+
+	for k, tc := range cases {
+		result := c.ActiveHours(tc.temperature)
+		if result != tc.expectedResult {
+			t.Fatalf("%s: ActiveHours\ngot:  %v\nwant: %v\n", k, result, tc.expectedResult)
+		}
+	}
+}
+
+func TestHTTPSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"temperature": -5.0, "forecast": [-7.0, -9.0, -11.0]}`))
+	}))
+	defer ts.Close()
+
+	s := NewHTTPSource(ts.URL, time.Hour, nil)
+	current, err := s.Current(context.Background())
+	if err != nil || current != -5.0 {
+		t.Fatalf("Current\ngot:  %v (%v)\nwant: %v\n", current, err, -5.0)
+	}
+
+	effective, err := Effective(context.Background(), s, 2)
+	if err != nil || effective != -7.0 {
+		t.Fatalf("Effective\ngot:  %v (%v)\nwant: %v\n", effective, err, -7.0)
+	}
+
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer invalid.Close()
+
+	if _, err := NewHTTPSource(invalid.URL, time.Hour, nil).Current(context.Background()); err == nil {
+		t.Errorf("Current() should have failed, but it succeeded")
+	}
+}
+
+func TestStaleTemperature(t *testing.T) {
+	start := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	var m sync.Mutex
+	response := `{"temperature": 21.5}`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Lock()
+		defer m.Unlock()
+		if response == "" {
+			// sleeping sensor does not answer
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(response))
+	}))
+	defer ts.Close()
+	respond := func(r string) {
+		m.Lock()
+		response = r
+		m.Unlock()
+	}
+
+	cases := []struct {
+		name          string
+		response      string
+		advance       time.Duration
+		expectedError bool
+	}{
+		{name: "Current temperature", response: `{"temperature": 21.5}`},
+		{name: "Latest temperature when sensor does not answer", advance: 30 * time.Minute},
+		{name: "Latest temperature is too old", advance: 31 * time.Minute, expectedError: true},
+		{name: "Sensor answers again", response: `{"temperature": 21.5}`},
+	}
+	fake := clock.NewFake(start)
+	s := NewHTTPSource(ts.URL, time.Hour, fake)
+	for _, c := range cases {
+		respond(c.response)
+		fake.Advance(c.advance)
+		current, err := s.Current(context.Background())
+		if (err != nil) != c.expectedError || (err == nil && current != 21.5) {
+			t.Fatalf("%s: Current\ngot:  %v (%v)\nwant: %v (error: %v)\n", c.name, current, err, 21.5, c.expectedError)
+		}
+	}
+
+	// endpoint tells the time of the reading
+	respond(`{"temperature": 21.5, "time": "2024-01-15T10:00:00Z"}`)
+	if _, err := NewHTTPSource(ts.URL, time.Hour, fake).Current(context.Background()); err == nil {
+		t.Errorf("Current() with too old reading should have failed, but it succeeded")
+	}
+
+	// pushed temperature ages out in the same way
+	p := NewPushSource(time.Hour, fake)
+	if _, err := p.Current(context.Background()); err == nil {
+		t.Errorf("Current() before the first push should have failed, but it succeeded")
+	}
+	var notified []float64
+	p.Notify(func(t float64) { notified = append(notified, t) })
+	p.Push(20.0)
+	if len(notified) != 1 || notified[0] != 20.0 {
+		t.Errorf("pushed temperature was not notified: %v", notified)
+	}
+	if current, err := p.Current(context.Background()); err != nil || current != 20.0 {
+		t.Fatalf("Current\ngot:  %v (%v)\nwant: %v\n", current, err, 20.0)
+	}
+	fake.Advance(2 * time.Hour)
+	if _, err := p.Current(context.Background()); err == nil {
+		t.Errorf("Current() with too old temperature should have failed, but it succeeded")
+	}
+}
+
+func TestFMISource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("place") != "helsinki" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Query().Get("storedquery_id") {
+		case fmiObservationsQuery:
+			w.Write([]byte(fmiObservations))
+		case fmiForecastQuery:
+			w.Write([]byte(fmiForecast))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	fake := clock.NewFake(time.Date(2022, 10, 28, 12, 30, 0, 0, time.UTC))
+	s := NewFMISource(ts.URL, "helsinki", 3*time.Hour, fake)
+	current, err := s.Current(context.Background())
+	if err != nil || current != -4.0 {
+		t.Fatalf("Current\ngot:  %v (%v)\nwant: %v\n", current, err, -4.0)
+	}
+
+	effective, err := Effective(context.Background(), s, 24)
+	if err != nil || effective != -6.0 {
+		t.Fatalf("Effective\ngot:  %v (%v)\nwant: %v\n", effective, err, -6.0)
+	}
+
+	if _, err := NewFMISource(ts.URL, "nowhere", 3*time.Hour, fake).Current(context.Background()); err == nil {
+		t.Errorf("Current() should have failed, but it succeeded")
+	}
+
+	// station has not reported for a while
+	fake.Advance(3 * time.Hour)
+	if _, err := s.Current(context.Background()); err == nil {
+		t.Errorf("Current() with too old observation should have failed, but it succeeded")
+	}
+}
+
+func TestShellyHTSource(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tmp":{"value":21.5,"units":"C","tC":21.5,"tF":70.7,"is_valid":true},"hum":{"value":40,"is_valid":true}}`))
+	}))
+	defer ts.Close()
+
+	current, err := NewShellyHTSource(ts.URL, time.Hour, nil).Current(context.Background())
+	if err != nil || current != 21.5 {
+		t.Fatalf("Current\ngot:  %v (%v)\nwant: %v\n", current, err, 21.5)
+	}
+}
+
+func TestMQTTPayload(t *testing.T) {
+	cases := map[string]struct {
+		payload        string
+		expectedResult float64
+		expectedError  bool
+	}{
+		"Plain number":             {payload: " 21.5\n", expectedResult: 21.5},
+		"JSON":                     {payload: `{"temperature": 19.0}`, expectedResult: 19.0},
+		"JSON without temperature": {payload: `{"humidity": 40}`, expectedError: true},
+		"Invalid":                  {payload: "warm", expectedError: true},
+	}
+
+	for k, tc := range cases {
+		result, err := parsePayload([]byte(tc.payload))
+		if (err != nil) != tc.expectedError || result != tc.expectedResult {
+			t.Fatalf("%s: parsePayload\ngot:  %v (%v)\nwant: %v\n", k, result, err, tc.expectedResult)
+		}
+	}
+
+	fake := clock.NewFake(time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC))
+	s := MQTTSource{latest: newLatest(time.Hour, fake), topic: "home/livingroom/temperature"}
+	if _, err := s.Current(context.Background()); err == nil {
+		t.Errorf("Current() should have failed, but it succeeded")
+	}
+	s.handle([]byte("20.5"))
+	if current, err := s.Current(context.Background()); err != nil || current != 20.5 {
+		t.Fatalf("Current\ngot:  %v (%v)\nwant: %v\n", current, err, 20.5)
+	}
+	fake.Advance(2 * time.Hour)
+	if _, err := s.Current(context.Background()); err == nil {
+		t.Errorf("Current() with too old temperature should have failed, but it succeeded")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/koovee/thermia/calendar"
+)
+
+const hourLayout = "2006-01-02T15:04"
+
+// parseTimeRange parses a time range from command line: a date (YYYY-MM-DD), an hour (YYYY-MM-DDTHH:MM) or a range of
+// them separated by two dots (e.g. 2022-12-01..2022-12-07, end date is inclusive)
+func parseTimeRange(str string, loc *time.Location) (from, to time.Time, err error) {
+	f, t, isRange := strings.Cut(str, "..")
+	if !isRange {
+		if hour, err := time.ParseInLocation(hourLayout, f, loc); err == nil {
+			return hour, hour.Add(time.Hour), nil
+		}
+	}
+	p, err := calendar.ParsePeriod(f, t, "", loc)
+	return p.From, p.To, err
+}